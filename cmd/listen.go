@@ -3,58 +3,220 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"slices"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/praswicaksono/turu/internal/conf"
 	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/metrics"
 	"github.com/praswicaksono/turu/internal/registry"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+var (
+	// actions which make container able to serve traffic
+	registerActions = []events.Action{
+		events.ActionStart,
+		events.ActionRestart,
+		events.ActionUnPause,
+	}
+
+	// actions which stop container gracefully, nodes drained before removed.
+	// Kill is ignored when its signal does not stop the container.
+	drainActions = []events.Action{
+		events.ActionKill,
+		events.ActionStop,
+	}
+
+	// actions which make container unable to serve traffic, registries ignore
+	// container already deregistered so kill followed by die is harmless
+	deregisterActions = []events.Action{
+		events.ActionDie,
+		events.ActionDestroy,
+		events.ActionPause,
+	}
+
+	// actions after which last known state of container is no longer needed,
+	// container started again is inspected again
+	forgetActions = []events.Action{
+		events.ActionDie,
+		events.ActionStop,
+		events.ActionDestroy,
+	}
+)
+
+// operation is what to do with container of an event
+type operation int
+
+const (
+	opNone operation = iota
+	opRegister
+	opWaitHealthy
+	opIgnoreSignal
+	opDrain
+	opDeregister
+)
+
 var listenCmd = &cobra.Command{
 	Use:   "listen",
 	Short: "Listen docker event and register to service discovery",
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if err := registry.Init(ctx, conf.TuruConfig); err != nil {
+			log.Fatal().Err(err).Msg("failed to initialize registries")
+		}
+
 		client := docker.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 		defer client.Close()
 
-		ctx := context.Background()
+		startedAt := time.Now()
+
+		// register containers which already running before turu started and
+		// remove nodes of containers which gone while turu was down
+		cnts, err := client.ListRunningContainers(ctx)
+		if err != nil {
+			log.Error().Stack().Err(err).Msg("failed to list running containers")
+		} else {
+			registry.Reconcile(log.With().Str("event", "startup").Logger().WithContext(ctx), cnts)
+		}
+
+		if conf.TuruConfig.Listen.MetricsAddr != "" {
+			metrics.Serve(conf.TuruConfig.Listen.MetricsAddr)
+		}
+
+		if conf.TuruConfig.Listen.ResyncInterval > 0 {
+			go resync(ctx, client, conf.TuruConfig.Listen.ResyncInterval)
+		}
+
+		// events of the same service are processed in order by the same worker
+		dispatcher := docker.NewDispatcher(
+			conf.TuruConfig.Listen.Workers,
+			conf.TuruConfig.Listen.QueueSize,
+			handleEvent(client),
+		)
+		dispatcher.Start(ctx)
 
 		client.ListenForDockerEvent(
 			ctx,
 			events.ListOptions{
-				Filters: filters.NewArgs(
-					filters.KeyValuePair{Key: "type", Value: "container"},
-					filters.KeyValuePair{Key: "event", Value: "start"},
-					filters.KeyValuePair{Key: "event", Value: "kill"},
-				),
-			},
-			func(ctx context.Context, cancelFunc context.CancelFunc, event events.Message) {
-				res, err := client.DockerManager.ContainerInspect(ctx, event.Actor.ID)
-				if err != nil {
-					log.Error().Err(err).Stack().Msg("")
-					return
-				}
-
-				ctx = log.With().
-					Str("event", fmt.Sprintf("%s-%s", string(event.Type), event.Action)).
-					Str("container_id", res.ID).
-					Str("name", res.Name).
-					Logger().WithContext(ctx)
-
-				switch event.Action {
-				case "start":
-					err = registry.HandleContainerCreateEvent(ctx, res)
-				case "kill":
-					err = registry.HandleContainerKillEvent(ctx, res)
-				}
-
-				if err != nil {
-					log.Ctx(ctx).Error().Stack().Err(err).Msg("")
-				}
+				// catch events which happened while reconciling running containers
+				Since:   docker.FormatSince(startedAt),
+				Filters: eventFilters(),
 			},
+			dispatcher.Dispatch,
 		)
 	},
 }
+
+func eventFilters() filters.Args {
+	f := filters.NewArgs(filters.KeyValuePair{Key: "type", Value: "container"})
+	for _, a := range slices.Concat(registerActions, drainActions, deregisterActions) {
+		f.Add("event", string(a))
+	}
+
+	// docker match any health_status event with this prefix
+	f.Add("event", string(events.ActionHealthStatus))
+
+	return f
+}
+
+// eventOperation map event of inspected container to operation
+func eventOperation(event events.Message, res types.ContainerJSON) operation {
+	switch {
+	case slices.Contains(registerActions, event.Action):
+		// container with healthcheck is registered on healthy status
+		if docker.WaitHealthy(res) && !docker.IsHealthy(res) {
+			return opWaitHealthy
+		}
+		return opRegister
+	case event.Action == events.ActionKill && !docker.IsStopSignal(res, event.Actor.Attributes["signal"]):
+		// e.g. HUP to reload, container keep running
+		return opIgnoreSignal
+	case slices.Contains(drainActions, event.Action):
+		return opDrain
+	case slices.Contains(deregisterActions, event.Action):
+		return opDeregister
+	case event.Action == events.ActionHealthStatusHealthy && docker.WaitHealthy(res):
+		return opRegister
+	case event.Action == events.ActionHealthStatusUnhealthy && docker.WaitHealthy(res):
+		return opDeregister
+	}
+
+	return opNone
+}
+
+func handleEvent(client *docker.Docker) docker.DockerEventHandler {
+	return func(ctx context.Context, cancelFunc context.CancelFunc, event events.Message) {
+		if slices.Contains(forgetActions, event.Action) {
+			defer client.ForgetContainer(event.Actor.ID)
+		}
+
+		res, err := client.InspectContainer(ctx, event.Actor.ID)
+		if errdefs.IsNotFound(err) && slices.Contains(forgetActions, event.Action) {
+			// removed after its state forgotten on die or stop, container was
+			// deregistered then
+			log.Debug().Str("container_id", event.Actor.ID).Str("action", string(event.Action)).Msg("container already gone, nothing deregistered")
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Stack().Msg("")
+			return
+		}
+
+		ctx = log.With().
+			Str("event", fmt.Sprintf("%s-%s", string(event.Type), event.Action)).
+			Str("container_id", res.ID).
+			Str("name", res.Name).
+			Logger().WithContext(ctx)
+
+		switch eventOperation(event, res) {
+		case opRegister:
+			err = registry.HandleContainerCreateEvent(ctx, res)
+		case opWaitHealthy:
+			log.Ctx(ctx).Info().Msg("waiting container to be healthy before registering")
+		case opIgnoreSignal:
+			log.Ctx(ctx).Info().Str("signal", event.Actor.Attributes["signal"]).Msg("container signaled without stopping, nothing deregistered")
+		case opDrain:
+			period := docker.GetDrainPeriod(res, conf.TuruConfig.Listen.DrainPeriod)
+			err = registry.HandleContainerDrainEvent(ctx, res, period)
+		case opDeregister:
+			err = registry.HandleContainerKillEvent(ctx, res)
+		}
+
+		if err != nil {
+			log.Ctx(ctx).Error().Stack().Err(err).Msg("")
+		}
+	}
+}
+
+// resync periodically converge registries to docker state, so missed or failed
+// events does not leave registry out of sync
+func resync(ctx context.Context, client *docker.Docker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx = log.With().Str("event", "resync").Logger().WithContext(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			listedAt := time.Now()
+			cnts, err := client.ListRunningContainers(ctx)
+			if err != nil {
+				log.Ctx(ctx).Error().Stack().Err(err).Msg("failed to list running containers")
+				continue
+			}
+
+			registry.Resync(ctx, cnts, listedAt)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/errdefs"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// fakeClient inspect containers kept in memory, docker.Client methods not
+// used by handleEvent are not implemented
+type fakeClient struct {
+	docker.Client
+	containers map[string]types.ContainerJSON
+}
+
+func (f *fakeClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	cnt, ok := f.containers[containerID]
+	if !ok {
+		return types.ContainerJSON{}, errdefs.NotFound(assert.AnError)
+	}
+
+	return cnt, nil
+}
+
+func event(action events.Action, attributes map[string]string) events.Message {
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: "whoami-1", Attributes: attributes},
+	}
+}
+
+func TestEventOperation(t *testing.T) {
+	withHealthcheck := func(status string) types.ContainerJSON {
+		cnt := dockertest.Container{}.Build()
+		cnt.Config.Healthcheck = &container.HealthConfig{Test: []string{"CMD", "true"}}
+		cnt.State = &types.ContainerState{Health: &types.Health{Status: status}}
+		return cnt
+	}
+
+	// data is event and inspected container
+	table := TestTable{
+		test: func(data any) (any, error) {
+			d := data.([]any)
+			return eventOperation(d[0].(events.Message), d[1].(types.ContainerJSON)), nil
+		},
+		assertion: map[string]TestAssertion{},
+	}
+
+	cases := map[string]struct {
+		event events.Message
+		cnt   types.ContainerJSON
+		op    operation
+	}{
+		"start":                    {event(events.ActionStart, nil), dockertest.Container{}.Build(), opRegister},
+		"restart":                  {event(events.ActionRestart, nil), dockertest.Container{}.Build(), opRegister},
+		"unpause":                  {event(events.ActionUnPause, nil), dockertest.Container{}.Build(), opRegister},
+		"start_wait_healthy":       {event(events.ActionStart, nil), withHealthcheck(types.Starting), opWaitHealthy},
+		"start_already_healthy":    {event(events.ActionStart, nil), withHealthcheck(types.Healthy), opRegister},
+		"kill_sigterm":             {event(events.ActionKill, map[string]string{"signal": "15"}), dockertest.Container{}.Build(), opDrain},
+		"kill_sighup":              {event(events.ActionKill, map[string]string{"signal": "1"}), dockertest.Container{}.Build(), opIgnoreSignal},
+		"stop":                     {event(events.ActionStop, nil), dockertest.Container{}.Build(), opDrain},
+		"die":                      {event(events.ActionDie, nil), dockertest.Container{}.Build(), opDeregister},
+		"destroy":                  {event(events.ActionDestroy, nil), dockertest.Container{}.Build(), opDeregister},
+		"pause":                    {event(events.ActionPause, nil), dockertest.Container{}.Build(), opDeregister},
+		"healthy":                  {event(events.ActionHealthStatusHealthy, nil), withHealthcheck(types.Healthy), opRegister},
+		"unhealthy":                {event(events.ActionHealthStatusUnhealthy, nil), withHealthcheck(types.Unhealthy), opDeregister},
+		"healthy_not_waiting":      {event(events.ActionHealthStatusHealthy, nil), dockertest.Container{}.Build(), opNone},
+		"unhealthy_not_waiting":    {event(events.ActionHealthStatusUnhealthy, nil), dockertest.Container{}.Build(), opNone},
+		"unrelated_action_ignored": {event(events.ActionCreate, nil), dockertest.Container{}.Build(), opNone},
+	}
+	for k, c := range cases {
+		table.assertion[k] = TestAssertion{
+			data: func() any {
+				return []any{c.event, c.cnt}
+			},
+			expectation: func(obj any, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, c.op, obj, k)
+			},
+		}
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
+
+func TestHandleEventForgetContainer(t *testing.T) {
+	conf.TuruConfig = &conf.Turu{Listen: &conf.Listen{}}
+
+	table := TestTable{
+		// container is removed after event handled, data is the event
+		test: func(data any) (any, error) {
+			ctx := context.Background()
+			f := &fakeClient{containers: map[string]types.ContainerJSON{
+				"whoami-1": dockertest.Container{}.Build(),
+			}}
+			client := docker.NewDocker(f)
+
+			// state remembered on start
+			if _, err := client.InspectContainer(ctx, "whoami-1"); err != nil {
+				return nil, err
+			}
+
+			handleEvent(client)(ctx, func() {}, data.(events.Message))
+			delete(f.containers, "whoami-1")
+
+			return client.InspectContainer(ctx, "whoami-1")
+		},
+		assertion: map[string]TestAssertion{
+			"die": {
+				data: func() any {
+					return event(events.ActionDie, nil)
+				},
+				expectation: func(obj any, err error) {
+					assert.True(t, errdefs.IsNotFound(err))
+				},
+			},
+			"stop": {
+				data: func() any {
+					return event(events.ActionStop, nil)
+				},
+				expectation: func(obj any, err error) {
+					assert.True(t, errdefs.IsNotFound(err))
+				},
+			},
+			"destroy": {
+				data: func() any {
+					return event(events.ActionDestroy, nil)
+				},
+				expectation: func(obj any, err error) {
+					assert.True(t, errdefs.IsNotFound(err))
+				},
+			},
+			"kill_keep_last_state": {
+				data: func() any {
+					return event(events.ActionKill, map[string]string{"signal": "1"})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, "/whoami-1", obj.(types.ContainerJSON).Name)
+				},
+			},
+			"pause_keep_last_state": {
+				data: func() any {
+					return event(events.ActionPause, nil)
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Run execute command line without shell, arguments are separated by
+// whitespace. Empty command line is a no-op.
+func Run(ctx context.Context, cmdline string) error {
+	args := strings.Fields(cmdline)
+	if len(args) == 0 {
+		return nil
+	}
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", cmdline, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
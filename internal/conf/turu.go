@@ -4,17 +4,49 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 )
 
 type Turu struct {
-	Config *Config `mapstructure:"config"`
+	// Config is legacy registry setting keyed by registry type
+	Config     map[string]map[string]any `mapstructure:"config"`
+	Registries map[string]Registry       `mapstructure:"registries"`
+	Listen     *Listen                   `mapstructure:"listen"`
 }
 
-type Config struct {
-	ApisixYaml *ApisixYaml `mapstructure:"apisix-yaml"`
-	ApisixEtcd *ApisixEtcd `mapstructure:"apisix-etcd"`
+type Listen struct {
+	ResyncInterval time.Duration `mapstructure:"resync-interval"`
+	MetricsAddr    string        `mapstructure:"metrics-addr"`
+	Workers        int           `mapstructure:"workers"`
+	QueueSize      int           `mapstructure:"queue-size"`
+	DrainPeriod    time.Duration `mapstructure:"drain-period"`
+}
+
+// Registry is named registry instance, Options hold the remaining keys of
+// the instance and are decoded by its Type
+type Registry struct {
+	Type    string         `mapstructure:"type"`
+	Options map[string]any `mapstructure:",remain"`
+}
+
+// DecodeOptions decode registry options into type specific config, duration
+// and comma separated list are accepted as string like viper does
+func DecodeOptions(options map[string]any, out any) error {
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.Decode(options)
 }
 
 type MTLS struct {
@@ -24,10 +56,12 @@ type MTLS struct {
 }
 
 type ApisixYaml struct {
-	Path string `mapstructure:"path"`
+	Path     string `mapstructure:"path"`
+	Instance string `mapstructure:"instance"`
 }
 
-type ApisixEtcd struct {
+// EtcdConnection is connection setting shared by registries backed by etcd
+type EtcdConnection struct {
 	Endpoint []string      `mapstructure:"endpoint"`
 	Timeout  time.Duration `mapstructure:"timeout"`
 	Username *string       `mapstructure:"username"`
@@ -35,6 +69,118 @@ type ApisixEtcd struct {
 	MTLS     *MTLS         `mapstructure:"mtls"`
 }
 
+type ApisixEtcd struct {
+	EtcdConnection `mapstructure:",squash"`
+	Instance       string `mapstructure:"instance"`
+}
+
+type Etcd struct {
+	EtcdConnection `mapstructure:",squash"`
+	Prefix         string        `mapstructure:"prefix"`
+	TTL            time.Duration `mapstructure:"ttl"`
+}
+
+type ApisixAdmin struct {
+	Endpoint string        `mapstructure:"endpoint"`
+	APIKey   string        `mapstructure:"api-key"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+type TraefikFile struct {
+	Path string `mapstructure:"path"`
+}
+
+type Nginx struct {
+	IncludeDir    string `mapstructure:"include-dir"`
+	CheckCommand  string `mapstructure:"check-command"`
+	ReloadCommand string `mapstructure:"reload-command"`
+}
+
+type EnvoyXDS struct {
+	Listen       string `mapstructure:"listen"`
+	ListenerPort uint32 `mapstructure:"listener-port"`
+}
+
+type Haproxy struct {
+	Socket        string        `mapstructure:"socket"`
+	Timeout       time.Duration `mapstructure:"timeout"`
+	ConfigDir     string        `mapstructure:"config-dir"`
+	CheckCommand  string        `mapstructure:"check-command"`
+	ReloadCommand string        `mapstructure:"reload-command"`
+}
+
+type Eureka struct {
+	URL               string        `mapstructure:"url"`
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat-interval"`
+	Timeout           time.Duration `mapstructure:"timeout"`
+}
+
+type Nacos struct {
+	Address           string        `mapstructure:"address"`
+	Namespace         string        `mapstructure:"namespace"`
+	Group             string        `mapstructure:"group"`
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat-interval"`
+	Timeout           time.Duration `mapstructure:"timeout"`
+}
+
+type Zookeeper struct {
+	Servers        []string      `mapstructure:"servers"`
+	BasePath       string        `mapstructure:"base-path"`
+	SessionTimeout time.Duration `mapstructure:"session-timeout"`
+}
+
+type KongYaml struct {
+	Path     string        `mapstructure:"path"`
+	AdminURL string        `mapstructure:"admin-url"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+type Redis struct {
+	Address  string        `mapstructure:"address"`
+	Username string        `mapstructure:"username"`
+	Password string        `mapstructure:"password"`
+	DB       int           `mapstructure:"db"`
+	Prefix   string        `mapstructure:"prefix"`
+	TTL      time.Duration `mapstructure:"ttl"`
+}
+
+type Webhook struct {
+	URLs       []string      `mapstructure:"urls"`
+	Secret     string        `mapstructure:"secret"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+	Retries    int           `mapstructure:"retries"`
+	Backoff    time.Duration `mapstructure:"backoff"`
+	DeadLetter string        `mapstructure:"dead-letter"`
+}
+
+type HostsFile struct {
+	Path string `mapstructure:"path"`
+}
+
+type DNS struct {
+	Listen string `mapstructure:"listen"`
+	Zone   string `mapstructure:"zone"`
+	TTL    uint32 `mapstructure:"ttl"`
+}
+
+type PrometheusFileSD struct {
+	Path string `mapstructure:"path"`
+}
+
+type Caddy struct {
+	Endpoint string        `mapstructure:"endpoint"`
+	Server   string        `mapstructure:"server"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+type Consul struct {
+	Address    string `mapstructure:"address"`
+	Scheme     string `mapstructure:"scheme"`
+	Datacenter string `mapstructure:"datacenter"`
+	Token      string `mapstructure:"token"`
+	TLS        *MTLS  `mapstructure:"tls"`
+}
+
 var TuruConfig *Turu
 
 func Init() {
@@ -46,6 +192,12 @@ func Init() {
 	viper.AddConfigPath("/etc/turu")
 	viper.SetEnvPrefix("TURU")
 
+	viper.SetDefault("listen.resync-interval", "60s")
+	viper.SetDefault("listen.metrics-addr", "")
+	viper.SetDefault("listen.workers", 8)
+	viper.SetDefault("listen.queue-size", 64)
+	viper.SetDefault("listen.drain-period", "0s")
+
 	if err := viper.ReadInConfig(); err == nil {
 		log.Info().Msg(fmt.Sprint("Using config file:", viper.ConfigFileUsed()))
 	}
@@ -1,12 +1,36 @@
 package docker
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/client"
 	"github.com/rs/zerolog/log"
 )
 
+// Client is docker api used by turu, it is implemented by *client.Client
+type Client interface {
+	Ping(ctx context.Context) (types.Ping, error)
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+	Close() error
+}
+
 type Docker struct {
-	DockerManager *client.Client
+	DockerManager Client
+
+	// last inspected state of containers, used when container already gone
+	containers map[string]types.ContainerJSON
+	m          sync.Mutex
+
+	// wait between reconnecting broken event stream
+	minBackoff time.Duration
+	maxBackoff time.Duration
 }
 
 func NewClientWithOpts(ops ...client.Opt) *Docker {
@@ -15,11 +39,53 @@ func NewClientWithOpts(ops ...client.Opt) *Docker {
 		log.Fatal().Err(err).Msg(err.Error())
 	}
 
+	return NewDocker(cli)
+}
+
+// NewDocker create docker using the given api client
+func NewDocker(cli Client) *Docker {
 	return &Docker{
 		DockerManager: cli,
+		containers:    make(map[string]types.ContainerJSON),
+		minBackoff:    minReconnectBackoff,
+		maxBackoff:    maxReconnectBackoff,
 	}
 }
 
 func (d *Docker) Close() {
 	d.DockerManager.Close()
 }
+
+// InspectContainer inspect container and remember its state, when container
+// already removed it returns the last known state instead
+func (d *Docker) InspectContainer(ctx context.Context, id string) (types.ContainerJSON, error) {
+	res, err := d.DockerManager.ContainerInspect(ctx, id)
+	if err != nil {
+		d.m.Lock()
+		defer d.m.Unlock()
+
+		if cnt, ok := d.containers[id]; ok && client.IsErrNotFound(err) {
+			return cnt, nil
+		}
+		return res, err
+	}
+
+	d.remember(res)
+
+	return res, nil
+}
+
+func (d *Docker) remember(cnt types.ContainerJSON) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.containers[cnt.ID] = cnt
+}
+
+// ForgetContainer remove last known state of container
+func (d *Docker) ForgetContainer(id string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	delete(d.containers, id)
+}
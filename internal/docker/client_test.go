@@ -0,0 +1,83 @@
+package docker_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/stretchr/testify/assert"
+)
+
+// inspectCase change docker state after container inspected once
+type inspectCase func(f *fakeClient, d *docker.Docker)
+
+func TestInspectContainer(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			ctx := context.Background()
+			f := &fakeClient{containers: map[string]types.ContainerJSON{
+				"whoami-1": dockertest.Container{}.Build(),
+			}}
+			d := docker.NewDocker(f)
+
+			if _, err := d.InspectContainer(ctx, "whoami-1"); err != nil {
+				return nil, err
+			}
+			data.(inspectCase)(f, d)
+
+			return d.InspectContainer(ctx, "whoami-1")
+		},
+		assertion: map[string]TestAssertion{
+			"running": {
+				data: func() any {
+					return inspectCase(func(f *fakeClient, d *docker.Docker) {})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, "/whoami-1", obj.(types.ContainerJSON).Name)
+				},
+			},
+			"removed_return_last_state": {
+				data: func() any {
+					return inspectCase(func(f *fakeClient, d *docker.Docker) {
+						delete(f.containers, "whoami-1")
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, "/whoami-1", obj.(types.ContainerJSON).Name)
+				},
+			},
+			"removed_after_forgotten": {
+				data: func() any {
+					return inspectCase(func(f *fakeClient, d *docker.Docker) {
+						delete(f.containers, "whoami-1")
+						d.ForgetContainer("whoami-1")
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.True(t, client.IsErrNotFound(err))
+				},
+			},
+			"other_error_not_hidden": {
+				data: func() any {
+					return inspectCase(func(f *fakeClient, d *docker.Docker) {
+						f.inspectErr = errBroken
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.ErrorIs(t, err, errBroken)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/praswicaksono/turu/internal/metrics"
+)
+
+type dispatchJob struct {
+	cancelFunc context.CancelFunc
+	event      events.Message
+}
+
+// Dispatcher process events of the same container and service in order, while
+// events of different services processed in parallel by a bounded pool of workers
+type Dispatcher struct {
+	queues  []chan dispatchJob
+	handler DockerEventHandler
+}
+
+func NewDispatcher(workers int, queueSize int, handler DockerEventHandler) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &Dispatcher{
+		queues:  make([]chan dispatchJob, workers),
+		handler: handler,
+	}
+
+	for i := range d.queues {
+		d.queues[i] = make(chan dispatchJob, queueSize)
+	}
+
+	return d
+}
+
+// Start run workers until ctx cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i, q := range d.queues {
+		go d.work(ctx, strconv.Itoa(i), q)
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context, worker string, q chan dispatchJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q:
+			metrics.DispatcherQueueDepth.Add(worker, -1)
+			d.handler(ctx, job.cancelFunc, job.event)
+		}
+	}
+}
+
+// Dispatch enqueue event to the worker owning its service, it blocks when the
+// worker queue is full. Its signature match DockerEventHandler.
+func (d *Dispatcher) Dispatch(ctx context.Context, cancelFunc context.CancelFunc, event events.Message) {
+	h := fnv.New32a()
+	h.Write([]byte(EventKey(event)))
+	i := int(h.Sum32() % uint32(len(d.queues)))
+
+	metrics.DispatcherEvents.Add(1)
+	metrics.DispatcherQueueDepth.Add(strconv.Itoa(i), 1)
+
+	select {
+	case <-ctx.Done():
+		metrics.DispatcherQueueDepth.Add(strconv.Itoa(i), -1)
+	case d.queues[i] <- dispatchJob{cancelFunc: cancelFunc, event: event}:
+	}
+}
+
+// EventKey return service name of event actor, it use the same rule as
+// GetContainerOrServiceName so containers of the same service share the key
+func EventKey(event events.Message) string {
+	attrs := event.Actor.Attributes
+
+	if project, ok := attrs["com.docker.compose.project"]; ok {
+		return fmt.Sprintf("%s-%s", project, attrs["com.docker.compose.service"])
+	}
+
+	if service, ok := attrs["turu.service"]; ok {
+		return service
+	}
+
+	if name, ok := attrs["name"]; ok {
+		return name
+	}
+
+	return event.Actor.ID
+}
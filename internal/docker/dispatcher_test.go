@@ -0,0 +1,75 @@
+package docker_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventKeyUseServiceName(t *testing.T) {
+	compose := events.Message{
+		Actor: events.Actor{
+			ID: "abc",
+			Attributes: map[string]string{
+				"com.docker.compose.project": "myproject",
+				"com.docker.compose.service": "webapp",
+				"name":                       "myproject-webapp-1",
+			},
+		},
+	}
+	service := events.Message{
+		Actor: events.Actor{
+			ID:         "abc",
+			Attributes: map[string]string{"turu.service": "whoami", "name": "whoami-1"},
+		},
+	}
+
+	assert.Equal(t, "myproject-webapp", docker.EventKey(compose))
+	assert.Equal(t, "whoami", docker.EventKey(service))
+	assert.Equal(t, "abc", docker.EventKey(events.Message{Actor: events.Actor{ID: "abc"}}))
+}
+
+// Events of the same service processed in dispatch order
+func TestDispatcherKeepOrderPerService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		m   sync.Mutex
+		wg  sync.WaitGroup
+		got = map[string][]string{}
+	)
+
+	d := docker.NewDispatcher(4, 2, func(ctx context.Context, cancelFunc context.CancelFunc, event events.Message) {
+		m.Lock()
+		defer m.Unlock()
+		key := docker.EventKey(event)
+		got[key] = append(got[key], string(event.Action))
+		wg.Done()
+	})
+	d.Start(ctx)
+
+	actions := []events.Action{"start", "kill", "start", "kill", "start"}
+	for _, svc := range []string{"a", "b", "c"} {
+		for _, action := range actions {
+			wg.Add(1)
+			d.Dispatch(ctx, cancel, events.Message{
+				Action: action,
+				Actor: events.Actor{
+					ID:         svc,
+					Attributes: map[string]string{"turu.service": svc},
+				},
+			})
+		}
+	}
+
+	wg.Wait()
+
+	for _, svc := range []string{"a", "b", "c"} {
+		assert.Equal(t, []string{"start", "kill", "start", "kill", "start"}, got[svc])
+	}
+}
@@ -0,0 +1,64 @@
+// Package dockertest build container fixtures shared by registry tests
+package dockertest
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// Container describe inspected container, zero value is container whoami-1
+// of service whoami exposing port 80 without network
+type Container struct {
+	// Name is container name without leading slash, it is used as ID too
+	Name    string
+	Service string
+	Labels  map[string]string
+	Ports   []string
+	IP      string
+	IPv6    string
+}
+
+func (c Container) Build() types.ContainerJSON {
+	if c.Name == "" {
+		c.Name = "whoami-1"
+	}
+	if c.Service == "" {
+		c.Service = "whoami"
+	}
+	if c.Ports == nil {
+		c.Ports = []string{"80/tcp"}
+	}
+
+	labels := map[string]string{"turu.service": c.Service}
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+
+	ports := nat.PortSet{}
+	for _, p := range c.Ports {
+		ports[nat.Port(p)] = struct{}{}
+	}
+
+	cnt := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   c.Name,
+			Name: "/" + c.Name,
+		},
+		Config: &container.Config{
+			Labels:       labels,
+			ExposedPorts: ports,
+		},
+	}
+
+	if c.IP != "" || c.IPv6 != "" {
+		cnt.NetworkSettings = &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"turu": {IPAddress: c.IP, GlobalIPv6Address: c.IPv6},
+			},
+		}
+	}
+
+	return cnt
+}
@@ -0,0 +1,9 @@
+package docker
+
+import "time"
+
+// SetBackoff change wait between reconnecting broken event stream
+func (d *Docker) SetBackoff(min time.Duration, max time.Duration) {
+	d.minBackoff = min
+	d.maxBackoff = max
+}
@@ -0,0 +1,49 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// ListRunningContainers inspect every running container labelled with turu
+// registry which able to serve traffic
+func (d *Docker) ListRunningContainers(ctx context.Context) ([]types.ContainerJSON, error) {
+	// docker label filter could not match indexed turu.registry.<n> labels,
+	// filter them here instead
+	list, err := d.DockerManager.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	cnts := make([]types.ContainerJSON, 0, len(list))
+	for _, c := range list {
+		if len(ParseRegistry(c.Labels)) == 0 {
+			continue
+		}
+
+		res, err := d.DockerManager.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			// container removed between list and inspect
+			if client.IsErrNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		d.remember(res)
+
+		// paused or not yet healthy container does not serve traffic
+		if res.State != nil && res.State.Paused {
+			continue
+		}
+		if WaitHealthy(res) && !IsHealthy(res) {
+			continue
+		}
+
+		cnts = append(cnts, res)
+	}
+
+	return cnts, nil
+}
@@ -2,32 +2,105 @@ package docker
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/docker/docker/api/types/events"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
 type DockerEventHandler func(ctx context.Context, cancelFunc context.CancelFunc, event events.Message)
 
+// ListenForDockerEvent listen docker event until ctx or cancelFunc passed to handler
+// is cancelled. When the stream breaks it reconnects with exponential backoff and
+// resume from the last seen event, so no event lost while docker daemon restarting.
+// opt.Since is only used for the first connection, it defaults to now.
+// Handler is called synchronously in event order, use Dispatcher to process
+// events concurrently.
 func (d *Docker) ListenForDockerEvent(ctx context.Context, opt events.ListOptions, handler DockerEventHandler) error {
 	eventCtx, eventCancel := context.WithCancel(ctx)
+	defer eventCancel()
+
+	var (
+		backoff = d.minBackoff
+		since   = opt.Since
+		last    events.Message
+	)
 
-	go func() {
-		msg, errs := d.DockerManager.Events(eventCtx, opt)
-		for {
-			select {
-			case err := <-errs:
-				log.Error().Err(err).Msg("")
-				return
-			case event := <-msg:
-				go func() {
-					handler(ctx, eventCancel, event)
-				}()
+	if since == "" {
+		since = FormatSince(time.Now())
+	}
+
+	for {
+		_, err := d.DockerManager.Ping(eventCtx)
+		if err == nil {
+			log.Info().Str("state", "connected").Str("since", since).Msg("listening docker event")
+			backoff = d.minBackoff
+			last, err = d.streamEvent(eventCtx, eventCancel, opt, since, last, handler)
+			if last.TimeNano > 0 {
+				since = FormatSince(time.Unix(0, last.TimeNano))
 			}
 		}
-	}()
 
-	<-eventCtx.Done()
+		if eventCtx.Err() != nil {
+			log.Info().Str("state", "stopped").Msg("stop listening docker event")
+			return nil
+		}
+
+		log.Error().Err(err).Str("state", "disconnected").Dur("retry_in", backoff).Msg("docker event stream broken")
+
+		select {
+		case <-eventCtx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, d.maxBackoff)
+	}
+}
+
+// streamEvent dispatch events since given time until the stream breaks, it
+// returns the last dispatched event
+func (d *Docker) streamEvent(
+	ctx context.Context,
+	cancelFunc context.CancelFunc,
+	opt events.ListOptions,
+	since string,
+	last events.Message,
+	handler DockerEventHandler,
+) (events.Message, error) {
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	defer streamCancel()
+
+	opt.Since = since
+	msg, errs := d.DockerManager.Events(streamCtx, opt)
+
+	for {
+		select {
+		case err := <-errs:
+			return last, err
+		case event := <-msg:
+			// since is inclusive, skip event which already dispatched
+			if isSameEvent(event, last) {
+				continue
+			}
+
+			last = event
+			handler(ctx, cancelFunc, event)
+		}
+	}
+}
+
+func isSameEvent(a events.Message, b events.Message) bool {
+	return a.TimeNano == b.TimeNano && a.Actor.ID == b.Actor.ID && a.Action == b.Action
+}
 
-	return nil
+// FormatSince format time as docker events since filter
+func FormatSince(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
 }
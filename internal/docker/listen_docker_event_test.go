@@ -0,0 +1,285 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+var errBroken = errors.New("unexpected EOF")
+
+// stream is events sent by single events call before it breaks with err
+type stream struct {
+	events []events.Message
+	err    error
+}
+
+// fakeClient replay given streams on every events call, after the last one
+// the stream stays open until ctx is done. Ping fails with the given errors
+// before succeeding. Inspect fails with inspectErr when set.
+type fakeClient struct {
+	m          sync.Mutex
+	pingErrors []error
+	streams    []stream
+	pings      []time.Time
+	since      []string
+	containers map[string]types.ContainerJSON
+	inspectErr error
+}
+
+func (f *fakeClient) Ping(ctx context.Context) (types.Ping, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.pings = append(f.pings, time.Now())
+	if len(f.pingErrors) > 0 {
+		err := f.pingErrors[0]
+		f.pingErrors = f.pingErrors[1:]
+		return types.Ping{}, err
+	}
+
+	return types.Ping{}, nil
+}
+
+func (f *fakeClient) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.since = append(f.since, options.Since)
+
+	msg := make(chan events.Message)
+	errs := make(chan error, 1)
+
+	var s *stream
+	if len(f.streams) > 0 {
+		s = &f.streams[0]
+		f.streams = f.streams[1:]
+	}
+
+	go func() {
+		if s == nil {
+			<-ctx.Done()
+			errs <- ctx.Err()
+			return
+		}
+
+		for _, e := range s.events {
+			select {
+			case msg <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		errs <- s.err
+	}()
+
+	return msg, errs
+}
+
+func (f *fakeClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.inspectErr != nil {
+		return types.ContainerJSON{}, f.inspectErr
+	}
+
+	cnt, ok := f.containers[containerID]
+	if !ok {
+		return types.ContainerJSON{}, errNotFound{}
+	}
+
+	return cnt, nil
+}
+
+func (f *fakeClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) Close() error {
+	return nil
+}
+
+// errNotFound is recognized by docker client as missing object
+type errNotFound struct{}
+
+func (errNotFound) Error() string { return "No such container" }
+func (errNotFound) NotFound()     {}
+
+func event(id string, action events.Action, at int64) events.Message {
+	return events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: id},
+		TimeNano: at,
+	}
+}
+
+// listenResult is events passed to handler, since of every events call and
+// time of every ping
+type listenResult struct {
+	handled []events.Message
+	since   []string
+	pings   []time.Time
+}
+
+// listenCase is docker api behaviour and number of events expected to be
+// handled before listening stop
+type listenCase struct {
+	client *fakeClient
+	since  string
+	expect int
+}
+
+func TestListenForDockerEvent(t *testing.T) {
+	first := event("web-1", events.ActionStart, 1_000_000_000)
+	second := event("web-1", events.ActionKill, 2_000_000_000)
+	third := event("web-1", events.ActionDie, 3_000_000_000)
+
+	table := TestTable{
+		test: func(data any) (any, error) {
+			c := data.(listenCase)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			d := docker.NewDocker(c.client)
+			d.SetBackoff(10*time.Millisecond, 40*time.Millisecond)
+
+			var res listenResult
+			err := d.ListenForDockerEvent(ctx, events.ListOptions{Since: c.since}, func(ctx context.Context, cancelFunc context.CancelFunc, event events.Message) {
+				res.handled = append(res.handled, event)
+				if len(res.handled) == c.expect {
+					cancelFunc()
+				}
+			})
+
+			c.client.m.Lock()
+			defer c.client.m.Unlock()
+			res.since = c.client.since
+			res.pings = c.client.pings
+
+			return res, errors.Join(err, ctx.Err())
+		},
+		assertion: map[string]TestAssertion{
+			"resume_from_last_event": {
+				data: func() any {
+					return listenCase{
+						client: &fakeClient{streams: []stream{
+							{events: []events.Message{first, second}, err: errBroken},
+							// since is inclusive, the last event is sent again
+							{events: []events.Message{second, third}, err: errBroken},
+						}},
+						since:  "1.000000000",
+						expect: 3,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(listenResult)
+					assert.NoError(t, err)
+					assert.Equal(t, []events.Message{first, second, third}, res.handled)
+					assert.Equal(t, []string{"1.000000000", "2.000000000"}, res.since)
+				},
+			},
+			"same_time_other_container_handled": {
+				data: func() any {
+					other := event("web-2", events.ActionKill, second.TimeNano)
+					return listenCase{
+						client: &fakeClient{streams: []stream{
+							{events: []events.Message{second}, err: errBroken},
+							{events: []events.Message{second, other}, err: errBroken},
+						}},
+						since:  "1.000000000",
+						expect: 2,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(listenResult)
+					assert.NoError(t, err)
+					assert.Len(t, res.handled, 2)
+					assert.Equal(t, "web-2", res.handled[1].Actor.ID)
+				},
+			},
+			"broken_before_any_event_keep_since": {
+				data: func() any {
+					return listenCase{
+						client: &fakeClient{streams: []stream{
+							{err: errBroken},
+							{events: []events.Message{first}, err: errBroken},
+						}},
+						since:  "1.000000000",
+						expect: 1,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(listenResult)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"1.000000000", "1.000000000"}, res.since)
+				},
+			},
+			"since_default_to_now": {
+				data: func() any {
+					return listenCase{
+						client: &fakeClient{streams: []stream{
+							{events: []events.Message{first}, err: errBroken},
+						}},
+						expect: 1,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(listenResult)
+					assert.NoError(t, err)
+					assert.Len(t, res.since, 1)
+					assert.Greater(t, res.since[0], docker.FormatSince(time.Now().Add(-time.Minute)))
+				},
+			},
+			"ping_failed_backoff": {
+				data: func() any {
+					return listenCase{
+						client: &fakeClient{
+							pingErrors: []error{errBroken, errBroken, errBroken, errBroken},
+							streams:    []stream{{events: []events.Message{first}, err: errBroken}},
+						},
+						since:  "1.000000000",
+						expect: 1,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(listenResult)
+					assert.NoError(t, err)
+					assert.Len(t, res.pings, 5)
+					assert.Len(t, res.since, 1)
+
+					// backoff doubles up to its maximum
+					for i, min := range []time.Duration{10, 20, 40, 40} {
+						assert.GreaterOrEqual(t, res.pings[i+1].Sub(res.pings[i]), min*time.Millisecond)
+					}
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -2,14 +2,27 @@ package docker
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/gookit/goutil"
+	"golang.org/x/sys/unix"
 )
 
 type LoadBalancerURL []string
 
+const registryLabel = "turu.registry"
+
+// serviceNamePattern is service name safe to be used as file name and inside
+// proxy configuration or runtime command
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 func GetContainerOrServiceName(cnt types.ContainerJSON) (string, string) {
 	var (
 		name    string
@@ -33,12 +46,50 @@ func GetContainerOrServiceName(cnt types.ContainerJSON) (string, string) {
 	return name, service
 }
 
-func GetRegistry(cnt types.ContainerJSON) string {
-	if goutil.Contains(cnt.Config.Labels, "turu.registry") {
-		return cnt.Config.Labels["turu.registry"]
+// ValidateServiceName reject service name taken from labels which could escape
+// configuration directory or inject configuration when written as is
+func ValidateServiceName(service string) error {
+	if !serviceNamePattern.MatchString(service) || strings.Trim(service, ".") == "" {
+		return fmt.Errorf("invalid service name %q, only letters, digits, '.', '_' and '-' are allowed", service)
 	}
 
-	return ""
+	return nil
+}
+
+// GetRegistry return registries of container from comma separated
+// turu.registry label and indexed turu.registry.<n> labels, in that order
+func GetRegistry(cnt types.ContainerJSON) []string {
+	return ParseRegistry(cnt.Config.Labels)
+}
+
+// ParseRegistry return registries from container labels without duplicate
+func ParseRegistry(labels map[string]string) []string {
+	var indexed []int
+	for k := range labels {
+		i, ok := strings.CutPrefix(k, registryLabel+".")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(i); err == nil {
+			indexed = append(indexed, n)
+		}
+	}
+	sort.Ints(indexed)
+
+	values := strings.Split(labels[registryLabel], ",")
+	for _, n := range indexed {
+		values = append(values, labels[fmt.Sprintf("%s.%d", registryLabel, n)])
+	}
+
+	var registries []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !slices.Contains(registries, v) {
+			registries = append(registries, v)
+		}
+	}
+
+	return registries
 }
 
 func GetLoadBalancerURL(name string, cnt types.ContainerJSON) LoadBalancerURL {
@@ -52,3 +103,103 @@ func GetLoadBalancerURL(name string, cnt types.ContainerJSON) LoadBalancerURL {
 
 	return lb
 }
+
+// WaitHealthy check whether container registration should wait until the
+// container is healthy. Container without healthcheck or labelled with
+// turu.wait-healthy=false is registered right away.
+func WaitHealthy(cnt types.ContainerJSON) bool {
+	if cnt.Config.Labels["turu.wait-healthy"] == "false" {
+		return false
+	}
+
+	hc := cnt.Config.Healthcheck
+	if hc == nil || len(hc.Test) == 0 || hc.Test[0] == "NONE" {
+		return false
+	}
+
+	return true
+}
+
+func IsHealthy(cnt types.ContainerJSON) bool {
+	return cnt.State != nil && cnt.State.Health != nil && cnt.State.Health.Status == types.Healthy
+}
+
+// GetDrainPeriod return how long container nodes drained before removed, it
+// can be overridden per container by turu.drain-period label
+func GetDrainPeriod(cnt types.ContainerJSON, fallback time.Duration) time.Duration {
+	v, ok := cnt.Config.Labels["turu.drain-period"]
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback
+	}
+
+	return d
+}
+
+// signals which stop container unless its process handle them
+var terminateSignals = []syscall.Signal{syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM}
+
+// IsStopSignal check whether signal number of kill event is meant to stop the
+// container, either terminating signal or the container stop signal. Signal
+// like HUP or USR1 commonly ask the process to reload instead. Unknown signal
+// is treated as stop.
+func IsStopSignal(cnt types.ContainerJSON, signal string) bool {
+	n, err := strconv.Atoi(signal)
+	if err != nil {
+		return true
+	}
+
+	if slices.Contains(terminateSignals, syscall.Signal(n)) {
+		return true
+	}
+
+	return cnt.Config != nil && parseSignal(cnt.Config.StopSignal) == syscall.Signal(n)
+}
+
+// parseSignal parse signal number or name with or without SIG prefix, it
+// returns 0 for unknown signal
+func parseSignal(s string) syscall.Signal {
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n)
+	}
+
+	s = strings.ToUpper(s)
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+
+	return unix.SignalNum(s)
+}
+
+// GetContainerIPs return ipv4 and ipv6 address of container, networks are
+// visited in name order so the result is stable
+func GetContainerIPs(cnt types.ContainerJSON) (ipv4 []string, ipv6 []string) {
+	if cnt.NetworkSettings == nil {
+		return nil, nil
+	}
+
+	networks := make([]string, 0, len(cnt.NetworkSettings.Networks))
+	for k := range cnt.NetworkSettings.Networks {
+		networks = append(networks, k)
+	}
+	sort.Strings(networks)
+
+	for _, k := range networks {
+		n := cnt.NetworkSettings.Networks[k]
+		if n == nil {
+			continue
+		}
+		if n.IPAddress != "" {
+			ipv4 = append(ipv4, n.IPAddress)
+		}
+		if n.GlobalIPv6Address != "" {
+			ipv6 = append(ipv6, n.GlobalIPv6Address)
+		}
+	}
+
+	return ipv4, ipv6
+}
@@ -99,5 +99,63 @@ func TestGetRegistryReturnsLabelValue(t *testing.T) {
 
 	r := docker.GetRegistry(container)
 
-	assert.Equal(t, "apisix", r)
+	assert.Equal(t, []string{"apisix"}, r)
+}
+
+func TestParseRegistryMultiple(t *testing.T) {
+	labels := map[string]string{
+		"turu.registry":       "apisix-etcd, prometheus-file-sd,",
+		"turu.registry.10":    "redis",
+		"turu.registry.2":     "consul",
+		"turu.registry.1":     "apisix-etcd",
+		"turu.registry.extra": "ignored",
+	}
+
+	assert.Equal(t, []string{"apisix-etcd", "prometheus-file-sd", "consul", "redis"}, docker.ParseRegistry(labels))
+	assert.Empty(t, docker.ParseRegistry(map[string]string{}))
+}
+
+func TestWaitHealthy(t *testing.T) {
+	healthcheck := &container.HealthConfig{Test: []string{"CMD", "true"}}
+
+	withHealthcheck := types.ContainerJSON{
+		Config: &container.Config{Healthcheck: healthcheck},
+	}
+	optOut := types.ContainerJSON{
+		Config: &container.Config{
+			Healthcheck: healthcheck,
+			Labels:      map[string]string{"turu.wait-healthy": "false"},
+		},
+	}
+	disabled := types.ContainerJSON{
+		Config: &container.Config{Healthcheck: &container.HealthConfig{Test: []string{"NONE"}}},
+	}
+
+	assert.True(t, docker.WaitHealthy(withHealthcheck))
+	assert.False(t, docker.WaitHealthy(optOut))
+	assert.False(t, docker.WaitHealthy(disabled))
+	assert.False(t, docker.WaitHealthy(types.ContainerJSON{Config: &container.Config{}}))
+}
+
+func TestIsStopSignal(t *testing.T) {
+	cnt := types.ContainerJSON{Config: &container.Config{}}
+	httpd := types.ContainerJSON{Config: &container.Config{StopSignal: "WINCH"}}
+
+	assert.True(t, docker.IsStopSignal(cnt, "15"))
+	assert.True(t, docker.IsStopSignal(cnt, "9"))
+	assert.True(t, docker.IsStopSignal(cnt, ""))
+	assert.False(t, docker.IsStopSignal(cnt, "1"))
+	assert.False(t, docker.IsStopSignal(cnt, "28"))
+	// container stop signal
+	assert.True(t, docker.IsStopSignal(httpd, "28"))
+}
+
+func TestValidateServiceName(t *testing.T) {
+	assert.NoError(t, docker.ValidateServiceName("whoami"))
+	assert.NoError(t, docker.ValidateServiceName("myproject-web_app.v2"))
+	assert.Error(t, docker.ValidateServiceName(""))
+	assert.Error(t, docker.ValidateServiceName(".."))
+	assert.Error(t, docker.ValidateServiceName("../../etc/cron.d/x"))
+	assert.Error(t, docker.ValidateServiceName("whoami; include /etc"))
+	assert.Error(t, docker.ValidateServiceName("whoami\nserver evil"))
 }
@@ -0,0 +1,37 @@
+package etcdutil
+
+import (
+	"github.com/praswicaksono/turu/internal/conf"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// NewClient create etcd client from connection setting, mtls is used only
+// when ca, cert and key are all set
+func NewClient(c conf.EtcdConnection) (*clientv3.Client, error) {
+	cnf := clientv3.Config{
+		Endpoints:   c.Endpoint,
+		DialTimeout: c.Timeout,
+	}
+
+	if c.Username != nil && c.Password != nil {
+		cnf.Username = *c.Username
+		cnf.Password = *c.Password
+	}
+
+	if c.MTLS != nil && c.MTLS.CA != "" && c.MTLS.Cert != "" && c.MTLS.Key != "" {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      c.MTLS.Cert,
+			KeyFile:       c.MTLS.Key,
+			TrustedCAFile: c.MTLS.CA,
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		cnf.TLS = tlsConfig
+	}
+
+	return clientv3.New(cnf)
+}
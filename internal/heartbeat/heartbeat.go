@@ -0,0 +1,98 @@
+package heartbeat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RenewFunc renew single instance registration
+type RenewFunc func(ctx context.Context) error
+
+// Heartbeat renew every added instance periodically, used by registries
+// which expire instance not renewed by its client
+type Heartbeat struct {
+	name     string
+	interval time.Duration
+
+	m        sync.Mutex
+	renewals map[string]RenewFunc
+}
+
+func New(name string, interval time.Duration) *Heartbeat {
+	return &Heartbeat{
+		name:     name,
+		interval: interval,
+		renewals: make(map[string]RenewFunc),
+	}
+}
+
+// Interval return duration between beats
+func (h *Heartbeat) Interval() time.Duration {
+	return h.interval
+}
+
+// Start renew instances every interval until ctx is cancelled
+func (h *Heartbeat) Start(ctx context.Context) {
+	go func() {
+		t := time.NewTicker(h.interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			err := h.Beat(ctx)
+			if err != nil {
+				log.Error().Err(err).Str("registry", h.name).Msg("heartbeat failed")
+			}
+		}
+	}()
+}
+
+// Add renew instance identified by key on every beat, existing key is replaced
+func (h *Heartbeat) Add(key string, renew RenewFunc) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.renewals[key] = renew
+}
+
+// Remove stop renewing instance, it returns false when key does not exist
+func (h *Heartbeat) Remove(key string) bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	_, ok := h.renewals[key]
+	delete(h.renewals, key)
+
+	return ok
+}
+
+// Beat renew every instance once, failure of one instance does not stop
+// renewal of the others
+func (h *Heartbeat) Beat(ctx context.Context) error {
+	h.m.Lock()
+	renewals := make(map[string]RenewFunc, len(h.renewals))
+	for k, v := range h.renewals {
+		renewals[k] = v
+	}
+	h.m.Unlock()
+
+	var errs []error
+	for k, renew := range renewals {
+		err := renew(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
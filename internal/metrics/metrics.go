@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ResyncRuns count finished resync between docker and registries
+	ResyncRuns = expvar.NewInt("turu_resync_runs")
+
+	// ResyncCorrections count route corrections made by resync, keyed by registry
+	ResyncCorrections = expvar.NewMap("turu_resync_corrections")
+
+	// DispatcherEvents count docker events dispatched to workers
+	DispatcherEvents = expvar.NewInt("turu_dispatcher_events")
+
+	// DispatcherQueueDepth track pending events, keyed by worker
+	DispatcherQueueDepth = expvar.NewMap("turu_dispatcher_queue_depth")
+)
+
+// Serve expose metrics as json on /debug/vars
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	go func() {
+		log.Info().Str("addr", addr).Msg("serving metrics")
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.Error().Err(err).Msg("metrics server stopped")
+		}
+	}()
+}
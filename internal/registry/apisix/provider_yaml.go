@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/goccy/go-yaml"
@@ -13,16 +15,27 @@ import (
 	"github.com/gookit/goutil/maputil"
 	"github.com/praswicaksono/turu/internal/conf"
 	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/rs/zerolog/log"
 )
 
 type RegistryYaml struct {
-	m *sync.Mutex
+	cfg   *conf.ApisixYaml
+	m     *sync.Mutex
+	clock routeClock
+}
+
+func NewRegistryYaml(cfg *conf.ApisixYaml) *RegistryYaml {
+	return &RegistryYaml{cfg: cfg}
 }
 
 func (p *RegistryYaml) Construct(ctx context.Context) {
 	if p.m == nil {
 		p.m = &sync.Mutex{}
 	}
+
+	if p.cfg.Instance == "" {
+		log.Ctx(ctx).Warn().Msg("instance is not set, stale routes and nodes are not removed by resync")
+	}
 }
 
 func (p *RegistryYaml) readConfig(path string) (*Config, error) {
@@ -59,7 +72,10 @@ func (p *RegistryYaml) Register(ctx context.Context, c types.ContainerJSON) erro
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	path := conf.TuruConfig.Config.ApisixYaml.Path
+	_, service := docker.GetContainerOrServiceName(c)
+	p.clock.touch(service)
+
+	path := p.cfg.Path
 
 	cfg, err := p.readConfig(path)
 
@@ -71,26 +87,26 @@ func (p *RegistryYaml) Register(ctx context.Context, c types.ContainerJSON) erro
 	if err != nil {
 		return err
 	}
+	added := maputil.Keys(r.Upstream.Nodes.(map[string]any))
 
-	rs := cfg.Routes[:0]
+	found := false
 
 	// loop current routes and merge node if exist
-	for _, v := range cfg.Routes {
+	for i, v := range cfg.Routes {
 		if v.ID == r.ID {
 			mn := maputil.Merge1level(v.Upstream.Nodes.(map[string]any), r.Upstream.Nodes.(map[string]any))
-			v.Upstream.Nodes = mn
-			rs = append(rs, v)
-		} else {
-			rs = append(rs, *r)
+			cfg.Routes[i].Upstream.Nodes = mn
+			MarkManagedRoute(&cfg.Routes[i])
+			OwnNodes(&cfg.Routes[i], p.cfg.Instance, added)
+			found = true
 		}
 	}
 
-	if len(rs) == 0 {
-		rs = append(rs, *r)
+	if !found {
+		OwnNodes(r, p.cfg.Instance, added)
+		cfg.Routes = append(cfg.Routes, *r)
 	}
 
-	cfg.Routes = rs
-
 	return p.writeConfig(path, cfg)
 }
 
@@ -98,7 +114,10 @@ func (p *RegistryYaml) Deregister(ctx context.Context, c types.ContainerJSON) er
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	path := conf.TuruConfig.Config.ApisixYaml.Path
+	_, service := docker.GetContainerOrServiceName(c)
+	p.clock.touch(service)
+
+	path := p.cfg.Path
 
 	cfg, err := p.readConfig(path)
 
@@ -110,24 +129,139 @@ func (p *RegistryYaml) Deregister(ctx context.Context, c types.ContainerJSON) er
 	lb := docker.GetLoadBalancerURL(name, c)
 
 	rs := cfg.Routes[:0]
+	changed := false
 
 	// search node, if found exclude from node list
 	for _, x := range cfg.Routes {
-		var nodes = make(map[string]int)
+		// keep weight of remaining nodes, some may be draining
+		var nodes = make(map[string]any)
 		currNodes := x.Upstream.Nodes.(map[string]any)
-		for k := range currNodes {
+		for k, v := range currNodes {
 			if !goutil.Contains(lb, k) {
-				nodes[k] = 1
+				nodes[k] = v
 			}
 		}
 
+		if len(nodes) != len(currNodes) {
+			DisownNodes(&x, lb)
+			changed = true
+		}
+
 		if len(nodes) > 0 {
 			x.Upstream.Nodes = nodes
 			rs = append(rs, x)
 		}
 	}
 
+	if !changed {
+		return nil
+	}
+
 	cfg.Routes = rs
 
 	return p.writeConfig(path, cfg)
 }
+
+func (p *RegistryYaml) Drain(ctx context.Context, c types.ContainerJSON) (bool, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	_, service := docker.GetContainerOrServiceName(c)
+	p.clock.touch(service)
+
+	path := p.cfg.Path
+
+	cfg, err := p.readConfig(path)
+	if err != nil {
+		return false, err
+	}
+
+	name, _ := docker.GetContainerOrServiceName(c)
+	lb := docker.GetLoadBalancerURL(name, c)
+
+	changed := false
+	for i := range cfg.Routes {
+		if DrainNodes(&cfg.Routes[i], lb) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	return true, p.writeConfig(path, cfg)
+}
+
+// Sync converge routes to containers, only nodes owned by this instance are
+// removed since the file may be shared by turu of several hosts. Nothing is
+// done when instance is not set.
+func (p *RegistryYaml) Sync(ctx context.Context, cnts []types.ContainerJSON, listedAt time.Time) (int, error) {
+	if p.cfg.Instance == "" {
+		return 0, nil
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path := p.cfg.Path
+
+	cfg, err := p.readConfig(path)
+	if err != nil {
+		return 0, err
+	}
+
+	desired := DesiredRoutes(cnts)
+	corrections := 0
+	rs := cfg.Routes[:0]
+
+	for _, x := range cfg.Routes {
+		// container list is older than the last event of the route
+		if p.clock.changedSince(x.Name, listedAt) {
+			delete(desired, x.Name)
+			rs = append(rs, x)
+			continue
+		}
+
+		d := desired[x.Name]
+		delete(desired, x.Name)
+
+		added, removed := ConvergeNodes(&x, d, p.cfg.Instance)
+		if len(added) == 0 && len(removed) == 0 {
+			rs = append(rs, x)
+			continue
+		}
+		corrections++
+
+		// route left without node is stale
+		if len(x.Upstream.Nodes.(map[string]any)) == 0 {
+			logCorrection(ctx, x.Name, "stale route removed", nil, removed)
+			continue
+		}
+
+		logCorrection(ctx, x.Name, "route nodes converged", added, removed)
+		rs = append(rs, x)
+	}
+
+	keys := maputil.Keys(desired)
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if p.clock.changedSince(k, listedAt) {
+			continue
+		}
+
+		logCorrection(ctx, k, "missing route added", nil, nil)
+		OwnNodes(desired[k], p.cfg.Instance, maputil.Keys(desired[k].Upstream.Nodes.(map[string]any)))
+		rs = append(rs, *desired[k])
+		corrections++
+	}
+
+	if corrections == 0 {
+		return 0, nil
+	}
+
+	cfg.Routes = rs
+
+	return corrections, p.writeConfig(path, cfg)
+}
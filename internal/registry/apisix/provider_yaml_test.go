@@ -0,0 +1,208 @@
+package apisix_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/registry/apisix"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncCase is file content before registration, turu instance and when
+// containers listed relative to registration
+type syncCase struct {
+	content  string
+	instance string
+	listed   time.Duration
+}
+
+// syncResult is corrections made by sync and routes left in the file
+type syncResult struct {
+	corrections int
+	routes      []apisix.Route
+}
+
+// otherInstanceRoute is route of whoami with node registered by host-b
+const otherInstanceRoute = `routes:
+  - id: whoami
+    name: whoami
+    uri: /*
+    host: example.com
+    labels:
+      managed-by: turu
+      turu.owner.whoami-9:80: host-b
+    upstream:
+      type: roundrobin
+      nodes:
+        whoami-9:80: 1
+    status: 1
+#END`
+
+func TestRegistryYamlSync(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			c := data.(syncCase)
+			path := filepath.Join(t.TempDir(), "apisix.yaml")
+			if err := os.WriteFile(path, []byte(c.content), 0644); err != nil {
+				return nil, err
+			}
+
+			ctx := context.Background()
+			r := apisix.NewRegistryYaml(&conf.ApisixYaml{Path: path, Instance: c.instance})
+			r.Construct(ctx)
+
+			listedAt := time.Now().Add(c.listed)
+			if err := r.Register(ctx, newAdminContainer("whoami-1")); err != nil {
+				return nil, err
+			}
+
+			// container gone from the list, route is stale unless registered
+			// after listing
+			n, err := r.Sync(ctx, nil, listedAt)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			var cfg apisix.Config
+			err = yaml.Unmarshal(b, &cfg)
+			return syncResult{corrections: n, routes: cfg.Routes}, err
+		},
+		assertion: map[string]TestAssertion{
+			"listed_before_register": {
+				data: func() any {
+					return syncCase{content: "routes: []\n#END", instance: "host-a", listed: -time.Second}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, 0, obj.(syncResult).corrections)
+				},
+			},
+			"listed_after_register": {
+				data: func() any {
+					return syncCase{content: "routes: []\n#END", instance: "host-a", listed: time.Second}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(syncResult)
+					assert.NoError(t, err)
+					assert.Equal(t, 1, res.corrections)
+					assert.Empty(t, res.routes)
+				},
+			},
+			"node_of_other_instance_kept": {
+				data: func() any {
+					return syncCase{content: otherInstanceRoute, instance: "host-a", listed: time.Second}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(syncResult)
+					assert.NoError(t, err)
+					assert.Equal(t, 1, res.corrections)
+					assert.Len(t, res.routes, 1)
+					assert.Equal(t, map[string]any{"whoami-9:80": uint64(1)}, res.routes[0].Upstream.Nodes)
+					assert.NotContains(t, res.routes[0].Labels, "turu.owner.whoami-1:80")
+				},
+			},
+			"instance_not_set": {
+				data: func() any {
+					return syncCase{content: "routes: []\n#END", listed: time.Second}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(syncResult)
+					assert.NoError(t, err)
+					assert.Equal(t, 0, res.corrections)
+					assert.Len(t, res.routes, 1)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
+
+func TestRegistryYamlDeregister(t *testing.T) {
+	table := TestTable{
+		// data is registry calls after whoami-1 and whoami-2 registered
+		test: func(data any) (any, error) {
+			path := filepath.Join(t.TempDir(), "apisix.yaml")
+			if err := os.WriteFile(path, []byte("routes: []\n#END"), 0644); err != nil {
+				return nil, err
+			}
+
+			ctx := context.Background()
+			r := apisix.NewRegistryYaml(&conf.ApisixYaml{Path: path})
+			r.Construct(ctx)
+
+			for _, name := range []string{"whoami-1", "whoami-2"} {
+				if err := r.Register(ctx, newAdminContainer(name)); err != nil {
+					return nil, err
+				}
+			}
+			if err := data.(func(ctx context.Context, r *apisix.RegistryYaml) error)(ctx, r); err != nil {
+				return nil, err
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			var cfg apisix.Config
+			err = yaml.Unmarshal(b, &cfg)
+			return cfg.Routes, err
+		},
+		assertion: map[string]TestAssertion{
+			"keep_draining_weight": {
+				data: func() any {
+					return func(ctx context.Context, r *apisix.RegistryYaml) error {
+						if err := r.Register(ctx, newAdminContainer("whoami-3")); err != nil {
+							return err
+						}
+						if _, err := r.Drain(ctx, newAdminContainer("whoami-1")); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, newAdminContainer("whoami-2"))
+					}
+				},
+				expectation: func(obj any, err error) {
+					routes := obj.([]apisix.Route)
+					assert.NoError(t, err)
+					assert.Len(t, routes, 1)
+					assert.Equal(t, map[string]any{"whoami-1:80": uint64(0), "whoami-3:80": uint64(1)}, routes[0].Upstream.Nodes)
+				},
+			},
+			"remove_route_without_node": {
+				data: func() any {
+					return func(ctx context.Context, r *apisix.RegistryYaml) error {
+						for _, name := range []string{"whoami-1", "whoami-2"} {
+							if err := r.Deregister(ctx, newAdminContainer(name)); err != nil {
+								return err
+							}
+						}
+						return nil
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package apisix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gookit/goutil"
+	"github.com/gookit/goutil/maputil"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+const defaultAdminTimeout = 5 * time.Second
+
+// RegistryAdmin register route and upstream through apisix admin api, route
+// refer to upstream named after the service which hold the nodes
+type RegistryAdmin struct {
+	cfg *conf.ApisixAdmin
+	c   *http.Client
+}
+
+func NewRegistryAdmin(cfg *conf.ApisixAdmin) *RegistryAdmin {
+	return &RegistryAdmin{cfg: cfg}
+}
+
+type adminResponse struct {
+	Value   json.RawMessage `json:"value"`
+	Message string          `json:"message"`
+	ErrMsg  string          `json:"error_msg"`
+}
+
+func (p *RegistryAdmin) Construct(ctx context.Context) {
+	if p.c == nil {
+		p.c = &http.Client{Timeout: defaultAdminTimeout}
+		if cfg := p.cfg; cfg != nil && cfg.Timeout > 0 {
+			p.c.Timeout = cfg.Timeout
+		}
+	}
+}
+
+// request call admin api and decode object value into out, it returns false
+// when object not found
+func (p *RegistryAdmin) request(ctx context.Context, method string, path string, body any, out any) (bool, error) {
+	cfg := p.cfg
+	if cfg == nil || cfg.Endpoint == "" {
+		return false, errors.New("apisix-admin.endpoint could not be empty")
+	}
+
+	var r io.Reader
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		r = bytes.NewReader(j)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(cfg.Endpoint, "/")+path, r)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-API-KEY", cfg.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.c.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	var ar adminResponse
+	err = json.NewDecoder(res.Body).Decode(&ar)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	if res.StatusCode >= 300 {
+		return false, fmt.Errorf("apisix admin %s %s: %d %s%s", method, path, res.StatusCode, ar.Message, ar.ErrMsg)
+	}
+
+	if out != nil && len(ar.Value) > 0 {
+		return true, json.Unmarshal(ar.Value, out)
+	}
+
+	return true, nil
+}
+
+func (p *RegistryAdmin) Register(ctx context.Context, c types.ContainerJSON) error {
+	newRoute, err := CreateRoute(c)
+	if err != nil {
+		return err
+	}
+
+	service := newRoute.Name
+	upstream := newRoute.Upstream
+	upstream.Name = service
+
+	// if upstream exist, merge upstream nodes
+	var currentUpstream UpstreamDef
+	found, err := p.request(ctx, http.MethodGet, "/apisix/admin/upstreams/"+service, nil, &currentUpstream)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		currNodes, _ := currentUpstream.Nodes.(map[string]any)
+		currentUpstream.Nodes = maputil.Merge1level(currNodes, upstream.Nodes.(map[string]any))
+		upstream = &currentUpstream
+	}
+
+	_, err = p.request(ctx, http.MethodPut, "/apisix/admin/upstreams/"+service, upstream, nil)
+	if err != nil {
+		return err
+	}
+
+	// keep existing route as is, only point it to the upstream
+	var currentRoute Route
+	found, err = p.request(ctx, http.MethodGet, "/apisix/admin/routes/"+service, nil, &currentRoute)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		currentRoute = *newRoute
+	}
+
+	currentRoute.Upstream = nil
+	currentRoute.UpstreamID = service
+	MarkManagedRoute(&currentRoute)
+
+	_, err = p.request(ctx, http.MethodPut, "/apisix/admin/routes/"+service, currentRoute, nil)
+
+	return err
+}
+
+func (p *RegistryAdmin) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	name, service := docker.GetContainerOrServiceName(c)
+
+	var currentUpstream UpstreamDef
+	found, err := p.request(ctx, http.MethodGet, "/apisix/admin/upstreams/"+service, nil, &currentUpstream)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return nil
+	}
+
+	lb := docker.GetLoadBalancerURL(name, c)
+
+	var nodes = make(map[string]any)
+	currNodes, _ := currentUpstream.Nodes.(map[string]any)
+	for k, v := range currNodes {
+		if !goutil.Contains(lb, k) {
+			nodes[k] = v
+		}
+	}
+
+	if len(nodes) == len(currNodes) {
+		return nil
+	}
+
+	// if there is no node left, delete the route then the upstream it refer to
+	if len(nodes) == 0 {
+		_, err = p.request(ctx, http.MethodDelete, "/apisix/admin/routes/"+service, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		_, err = p.request(ctx, http.MethodDelete, "/apisix/admin/upstreams/"+service, nil, nil)
+		return err
+	}
+
+	currentUpstream.Nodes = nodes
+	_, err = p.request(ctx, http.MethodPut, "/apisix/admin/upstreams/"+service, currentUpstream, nil)
+
+	return err
+}
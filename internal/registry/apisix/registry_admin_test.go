@@ -0,0 +1,167 @@
+package apisix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/apisix"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAdmin implement subset of apisix admin api used by turu
+type fakeAdmin struct {
+	m       sync.Mutex
+	objects map[string]json.RawMessage
+}
+
+func (f *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if r.Header.Get("X-API-KEY") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message":"failed to check token"}`))
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/apisix/admin")
+	obj, ok := f.objects[key]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Key not found"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"key": "/apisix" + key, "value": obj})
+	case http.MethodPut:
+		var v json.RawMessage
+		json.NewDecoder(r.Body).Decode(&v)
+		f.objects[key] = v
+		json.NewEncoder(w).Encode(map[string]any{"key": "/apisix" + key, "value": v})
+	case http.MethodDelete:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// upstream could not be deleted while route still refer to it
+		if strings.HasPrefix(key, "/upstreams/") && f.objects["/routes/"+strings.TrimPrefix(key, "/upstreams/")] != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error_msg":"can not delete this upstream, route is still using it now"}`))
+			return
+		}
+		delete(f.objects, key)
+		w.Write([]byte(`{"deleted":"1"}`))
+	}
+}
+
+func (f *fakeAdmin) nodes(t *testing.T, service string) map[string]any {
+	var u apisix.UpstreamDef
+	assert.NoError(t, json.Unmarshal(f.objects["/upstreams/"+service], &u))
+	return u.Nodes.(map[string]any)
+}
+
+func newAdminContainer(name string) types.ContainerJSON {
+	return dockertest.Container{
+		Name: name,
+		Labels: map[string]string{
+			"turu.apisix.host": "example.com",
+			"turu.apisix.uri":  "/*",
+		},
+	}.Build()
+}
+
+// adminScenario is registry calls of single test case
+type adminScenario = func(ctx context.Context, r *apisix.RegistryAdmin) error
+
+func registerAdmin(ctx context.Context, r *apisix.RegistryAdmin) error {
+	if err := r.Register(ctx, newAdminContainer("whoami-1")); err != nil {
+		return err
+	}
+	return r.Register(ctx, newAdminContainer("whoami-2"))
+}
+
+func deregisterAdmin(names ...string) adminScenario {
+	return func(ctx context.Context, r *apisix.RegistryAdmin) error {
+		if err := registerAdmin(ctx, r); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := r.Deregister(ctx, newAdminContainer(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestRegistryAdmin(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			admin := &fakeAdmin{objects: map[string]json.RawMessage{}}
+			srv := httptest.NewServer(admin)
+			defer srv.Close()
+
+			ctx := context.Background()
+			r := apisix.NewRegistryAdmin(&conf.ApisixAdmin{
+				Endpoint: srv.URL,
+				APIKey:   "secret",
+			})
+			r.Construct(ctx)
+
+			return admin, data.(adminScenario)(ctx, r)
+		},
+		assertion: map[string]TestAssertion{
+			"register": {
+				data: func() any {
+					return adminScenario(registerAdmin)
+				},
+				expectation: func(obj any, err error) {
+					admin := obj.(*fakeAdmin)
+					assert.NoError(t, err)
+
+					var route apisix.Route
+					assert.NoError(t, json.Unmarshal(admin.objects["/routes/whoami"], &route))
+					assert.Equal(t, "whoami", route.UpstreamID)
+					assert.Equal(t, "example.com", route.Host)
+					assert.Nil(t, route.Upstream)
+					assert.True(t, apisix.IsManagedRoute(route))
+					assert.Equal(t, 2, len(admin.nodes(t, "whoami")))
+				},
+			},
+			"deregister_one": {
+				data: func() any {
+					return deregisterAdmin("whoami-1")
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, map[string]any{"whoami-2:80": float64(1)}, obj.(*fakeAdmin).nodes(t, "whoami"))
+				},
+			},
+			"deregister_all_twice": {
+				data: func() any {
+					return deregisterAdmin("whoami-1", "whoami-2", "whoami-2")
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj.(*fakeAdmin).objects)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -5,88 +5,87 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/gookit/goutil"
 	"github.com/gookit/goutil/maputil"
 	"github.com/praswicaksono/turu/internal/conf"
 	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/etcdutil"
 	"github.com/rs/zerolog/log"
-	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
 type RegistryEtcd struct {
-	ec *clientv3.Client
-	c  *concurrency.Session
+	cfg   *conf.ApisixEtcd
+	ec    *clientv3.Client
+	clock routeClock
 }
 
-func (p *RegistryEtcd) createEtcdClient() *clientv3.Client {
-	cnf := clientv3.Config{
-		Endpoints:   conf.TuruConfig.Config.ApisixEtcd.Endpoint,
-		DialTimeout: conf.TuruConfig.Config.ApisixEtcd.Timeout,
-	}
+func NewRegistryEtcd(cfg *conf.ApisixEtcd) *RegistryEtcd {
+	return &RegistryEtcd{cfg: cfg}
+}
 
-	if conf.TuruConfig.Config.ApisixEtcd.Username != nil && conf.TuruConfig.Config.ApisixEtcd.Password != nil {
-		cnf.Username = *conf.TuruConfig.Config.ApisixEtcd.Username
-		cnf.Password = *conf.TuruConfig.Config.ApisixEtcd.Password
+func (p *RegistryEtcd) createEtcdClient() *clientv3.Client {
+	cli, err := etcdutil.NewClient(p.cfg.EtcdConnection)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
 	}
 
-	if conf.TuruConfig.Config.ApisixEtcd.MTLS != nil {
-		if conf.TuruConfig.Config.ApisixEtcd.MTLS.CA != "" && conf.TuruConfig.Config.ApisixEtcd.MTLS.Cert != "" && conf.TuruConfig.Config.ApisixEtcd.MTLS.Key != "" {
-			tlsInfo := transport.TLSInfo{
-				CertFile:      conf.TuruConfig.Config.ApisixEtcd.MTLS.Cert,
-				KeyFile:       conf.TuruConfig.Config.ApisixEtcd.MTLS.Key,
-				TrustedCAFile: conf.TuruConfig.Config.ApisixEtcd.MTLS.CA,
-			}
-			tlsConfig, err := tlsInfo.ClientConfig()
-			if err != nil {
-				log.Fatal().Err(err).Msg("")
-			}
-
-			cnf.TLS = tlsConfig
-		}
-	}
+	return cli
+}
 
-	cli, err := clientv3.New(cnf)
+// lock create mutex within its own session, so concurrent registration does not
+// close each other session
+func (p *RegistryEtcd) lock(name string) (*concurrency.Mutex, *concurrency.Session, error) {
+	s, err := concurrency.NewSession(p.ec)
 	if err != nil {
-		log.Fatal().Err(err).Msg("")
+		return nil, nil, err
 	}
 
-	return cli
+	return concurrency.NewMutex(s, name), s, nil
 }
 
-func (p *RegistryEtcd) lock(name string) (*concurrency.Mutex, error) {
-	var err error
-	p.c, err = concurrency.NewSession(p.ec)
+// lockRoute wait for lock shared by every operation of the route, so event
+// handling and resync of the same route never interleave, then mark the route
+// as changed by event. Closing returned session release the lock.
+func (p *RegistryEtcd) lockRoute(ctx context.Context, servicename string) (*concurrency.Session, error) {
+	lock, session, err := p.lock(fmt.Sprintf("/turu-apisix-etcd-route-%s/", servicename))
+	if err != nil {
+		return nil, err
+	}
+
+	err = lock.Lock(ctx)
 	if err != nil {
+		session.Close()
 		return nil, err
 	}
+	p.clock.touch(servicename)
 
-	return concurrency.NewMutex(p.c, name), nil
+	return session, nil
 }
 
 func (p *RegistryEtcd) Construct(ctx context.Context) {
 	if p.ec == nil {
 		p.ec = p.createEtcdClient()
 	}
+
+	if p.cfg.Instance == "" {
+		log.Ctx(ctx).Warn().Msg("instance is not set, stale routes and nodes are not removed by resync")
+	}
 }
 
 func (p *RegistryEtcd) Register(ctx context.Context, c types.ContainerJSON) error {
 	_, servicename := docker.GetContainerOrServiceName(c)
 
-	lock, err := p.lock(fmt.Sprintf("/turu-apisix-etcd-create-%s/", servicename))
-	if err != nil {
-		return err
-	}
-	defer p.c.Close()
-
-	// check if lock already by another process, skip if already locked
-	err = lock.TryLock(ctx)
+	session, err := p.lockRoute(ctx, servicename)
 	if err != nil {
 		return err
 	}
+	defer session.Close()
 
 	key := "/apisix/routes/" + servicename
 
@@ -99,9 +98,12 @@ func (p *RegistryEtcd) Register(ctx context.Context, c types.ContainerJSON) erro
 	if err != nil {
 		return err
 	}
+	added := maputil.Keys(newRoute.Upstream.Nodes.(map[string]any))
 
 	// add new route if not exist
 	if res.Count == 0 {
+		OwnNodes(newRoute, p.cfg.Instance, added)
+
 		j, err := json.Marshal(newRoute)
 		if err != nil {
 			return err
@@ -123,6 +125,8 @@ func (p *RegistryEtcd) Register(ctx context.Context, c types.ContainerJSON) erro
 	}
 
 	currentRoute.Upstream.Nodes = maputil.Merge1level(currentRoute.Upstream.Nodes.(map[string]any), newRoute.Upstream.Nodes.(map[string]any))
+	MarkManagedRoute(&currentRoute)
+	OwnNodes(&currentRoute, p.cfg.Instance, added)
 
 	j, err := json.Marshal(currentRoute)
 	if err != nil {
@@ -139,17 +143,11 @@ func (p *RegistryEtcd) Register(ctx context.Context, c types.ContainerJSON) erro
 func (p *RegistryEtcd) Deregister(ctx context.Context, c types.ContainerJSON) error {
 	name, servicename := docker.GetContainerOrServiceName(c)
 
-	lock, err := p.lock(fmt.Sprintf("/turu-apisix-etcd-remove-%s/", servicename))
-	if err != nil {
-		return err
-	}
-	defer p.c.Close()
-
-	// check if lock already by another process, skip if already locked
-	err = lock.TryLock(ctx)
+	session, err := p.lockRoute(ctx, servicename)
 	if err != nil {
 		return err
 	}
+	defer session.Close()
 
 	key := "/apisix/routes/" + servicename
 
@@ -159,7 +157,8 @@ func (p *RegistryEtcd) Deregister(ctx context.Context, c types.ContainerJSON) er
 	}
 
 	if res.Count == 0 {
-		return errors.New("route not found, nothing deregistered")
+		log.Ctx(ctx).Debug().Str("route", servicename).Msg("route not found, nothing deregistered")
+		return nil
 	}
 
 	body := res.Kvs[0].Value
@@ -172,14 +171,19 @@ func (p *RegistryEtcd) Deregister(ctx context.Context, c types.ContainerJSON) er
 
 	lb := docker.GetLoadBalancerURL(name, c)
 
-	var nodes = make(map[string]int)
+	// keep weight of remaining nodes, some may be draining
+	var nodes = make(map[string]any)
 	currNodes := currentRoute.Upstream.Nodes.(map[string]any)
-	for k := range currNodes {
+	for k, v := range currNodes {
 		if !goutil.Contains(lb, k) {
-			nodes[k] = 1
+			nodes[k] = v
 		}
 	}
 
+	if len(nodes) == len(currNodes) {
+		return nil
+	}
+
 	// if there is no node left, delete the route
 	if len(nodes) == 0 {
 		_, err = p.ec.Delete(ctx, key)
@@ -191,6 +195,7 @@ func (p *RegistryEtcd) Deregister(ctx context.Context, c types.ContainerJSON) er
 
 	// otherwise, update the route with new node list
 	currentRoute.Upstream.Nodes = nodes
+	DisownNodes(&currentRoute, lb)
 
 	j, err := json.Marshal(currentRoute)
 	if err != nil {
@@ -204,3 +209,167 @@ func (p *RegistryEtcd) Deregister(ctx context.Context, c types.ContainerJSON) er
 
 	return nil
 }
+
+func (p *RegistryEtcd) Drain(ctx context.Context, c types.ContainerJSON) (bool, error) {
+	name, servicename := docker.GetContainerOrServiceName(c)
+
+	session, err := p.lockRoute(ctx, servicename)
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+
+	key := "/apisix/routes/" + servicename
+
+	res, err := p.ec.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if res.Count == 0 {
+		return false, nil
+	}
+
+	var currentRoute Route
+	err = json.Unmarshal(res.Kvs[0].Value, &currentRoute)
+	if err != nil {
+		return false, err
+	}
+
+	if !DrainNodes(&currentRoute, docker.GetLoadBalancerURL(name, c)) {
+		return false, nil
+	}
+
+	j, err := json.Marshal(currentRoute)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = p.ec.Put(ctx, key, string(j))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Sync converge routes to containers, only nodes owned by this instance are
+// removed since etcd may be shared by turu of several hosts. Nothing is done
+// when instance is not set.
+func (p *RegistryEtcd) Sync(ctx context.Context, cnts []types.ContainerJSON, listedAt time.Time) (int, error) {
+	if p.cfg.Instance == "" {
+		return 0, nil
+	}
+
+	res, err := p.ec.Get(ctx, "/apisix/routes/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return 0, err
+	}
+
+	desired := DesiredRoutes(cnts)
+	services := make(map[string]struct{})
+	for _, kv := range res.Kvs {
+		services[strings.TrimPrefix(string(kv.Key), "/apisix/routes/")] = struct{}{}
+	}
+	for k := range desired {
+		services[k] = struct{}{}
+	}
+
+	corrections := 0
+	for k := range services {
+		corrected, err := p.syncRoute(ctx, k, desired[k], listedAt)
+		if err != nil {
+			return corrections, err
+		}
+		if corrected {
+			corrections++
+		}
+	}
+
+	return corrections, nil
+}
+
+// syncRoute converge single route to desired route under lock, desired is nil
+// when no container registered for the route
+func (p *RegistryEtcd) syncRoute(ctx context.Context, servicename string, desired *Route, listedAt time.Time) (bool, error) {
+	lock, session, err := p.lock(fmt.Sprintf("/turu-apisix-etcd-route-%s/", servicename))
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+
+	// route is being changed by event, next resync will check it again
+	err = lock.TryLock(ctx)
+	if errors.Is(err, concurrency.ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// container list is older than the last event of the route
+	if p.clock.changedSince(servicename, listedAt) {
+		return false, nil
+	}
+
+	key := "/apisix/routes/" + servicename
+
+	res, err := p.ec.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if res.Count == 0 {
+		if desired == nil {
+			return false, nil
+		}
+
+		OwnNodes(desired, p.cfg.Instance, maputil.Keys(desired.Upstream.Nodes.(map[string]any)))
+
+		j, err := json.Marshal(desired)
+		if err != nil {
+			return false, err
+		}
+		_, err = p.ec.Put(ctx, key, string(j))
+		if err != nil {
+			return false, err
+		}
+
+		logCorrection(ctx, servicename, "missing route added", nil, nil)
+		return true, nil
+	}
+
+	var currentRoute Route
+	err = json.Unmarshal(res.Kvs[0].Value, &currentRoute)
+	if err != nil {
+		return false, err
+	}
+
+	added, removed := ConvergeNodes(&currentRoute, desired, p.cfg.Instance)
+	if len(added) == 0 && len(removed) == 0 {
+		return false, nil
+	}
+
+	// route left without node is stale
+	if len(currentRoute.Upstream.Nodes.(map[string]any)) == 0 {
+		_, err = p.ec.Delete(ctx, key)
+		if err != nil {
+			return false, err
+		}
+
+		logCorrection(ctx, servicename, "stale route removed", nil, removed)
+		return true, nil
+	}
+
+	j, err := json.Marshal(currentRoute)
+	if err != nil {
+		return false, err
+	}
+	_, err = p.ec.Put(ctx, key, string(j))
+	if err != nil {
+		return false, err
+	}
+
+	logCorrection(ctx, servicename, "route nodes converged", added, removed)
+	return true, nil
+}
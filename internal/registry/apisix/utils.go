@@ -1,17 +1,31 @@
 package apisix
 
 import (
+	"context"
 	"errors"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/gookit/goutil"
+	"github.com/gookit/goutil/maputil"
+	"github.com/gookit/goutil/mathutil"
 	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/rs/zerolog/log"
 )
 
 var (
 	LABEL_TURU_APISIX_URI  = "turu.apisix.uri"
 	LABEL_TURU_APISIX_HOST = "turu.apisix.host"
+
+	ROUTE_LABEL_MANAGED_BY = "managed-by"
+	ROUTE_MANAGED_BY_TURU  = "turu"
+
+	// ROUTE_LABEL_NODE_OWNER is prefix of route label holding turu instance
+	// which registered the node, e.g. turu.owner.whoami-1:80=host-a
+	ROUTE_LABEL_NODE_OWNER = "turu.owner."
 )
 
 type ApisixLabel map[string]string
@@ -76,9 +90,169 @@ func CreateRoute(cnt types.ContainerJSON) (*Route, error) {
 			Nodes: nodes,
 			Type:  "roundrobin",
 		},
+		Labels: map[string]string{
+			ROUTE_LABEL_MANAGED_BY: ROUTE_MANAGED_BY_TURU,
+		},
 		Status: 1,
 	}
 	r.Creating()
 
 	return r, nil
 }
+
+// IsManagedRoute check whether route is created by turu. Route written before
+// the label existed is not managed until registered again, see README for
+// removing the ones which never will be.
+func IsManagedRoute(r Route) bool {
+	return r.Labels[ROUTE_LABEL_MANAGED_BY] == ROUTE_MANAGED_BY_TURU
+}
+
+// MarkManagedRoute label route as created by turu, routes registered before
+// the label existed get it on next registration
+func MarkManagedRoute(r *Route) {
+	if r.Labels == nil {
+		r.Labels = make(map[string]string)
+	}
+	r.Labels[ROUTE_LABEL_MANAGED_BY] = ROUTE_MANAGED_BY_TURU
+}
+
+// OwnNodes record instance as owner of given nodes, nothing is recorded when
+// instance is not set
+func OwnNodes(r *Route, instance string, nodes []string) {
+	if instance == "" {
+		return
+	}
+
+	if r.Labels == nil {
+		r.Labels = make(map[string]string)
+	}
+	for _, k := range nodes {
+		r.Labels[ROUTE_LABEL_NODE_OWNER+k] = instance
+	}
+}
+
+// DisownNodes remove owner of nodes removed from route
+func DisownNodes(r *Route, nodes []string) {
+	for _, k := range nodes {
+		delete(r.Labels, ROUTE_LABEL_NODE_OWNER+k)
+	}
+}
+
+// DesiredRoutes build routes of given containers, nodes of containers with
+// the same service merged into one route
+func DesiredRoutes(cnts []types.ContainerJSON) map[string]*Route {
+	routes := make(map[string]*Route)
+
+	for _, c := range cnts {
+		r, err := CreateRoute(c)
+		if err != nil {
+			continue
+		}
+
+		if curr, ok := routes[r.Name]; ok {
+			curr.Upstream.Nodes = maputil.Merge1level(curr.Upstream.Nodes.(map[string]any), r.Upstream.Nodes.(map[string]any))
+			continue
+		}
+
+		routes[r.Name] = r
+	}
+
+	return routes
+}
+
+// ConvergeNodes add missing desired nodes to route and remove nodes owned by
+// instance which are not desired, while keeping weight of existing nodes.
+// Desired is nil when no container of the route is running. Nodes registered
+// by other instance sharing the registry and route not created by turu are
+// left as is.
+func ConvergeNodes(r *Route, desired *Route, instance string) (added []string, removed []string) {
+	if !IsManagedRoute(*r) {
+		return nil, nil
+	}
+
+	currNodes, ok := r.Upstream.Nodes.(map[string]any)
+	if !ok {
+		currNodes = map[string]any{}
+	}
+	desiredNodes := map[string]any{}
+	if desired != nil {
+		desiredNodes = desired.Upstream.Nodes.(map[string]any)
+	}
+
+	nodes := make(map[string]any)
+	for k, v := range currNodes {
+		if goutil.Contains(desiredNodes, k) || r.Labels[ROUTE_LABEL_NODE_OWNER+k] != instance {
+			nodes[k] = v
+			continue
+		}
+		removed = append(removed, k)
+	}
+
+	for k, v := range desiredNodes {
+		if !goutil.Contains(currNodes, k) {
+			nodes[k] = v
+			added = append(added, k)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	r.Upstream.Nodes = nodes
+	OwnNodes(r, instance, added)
+	DisownNodes(r, removed)
+
+	return added, removed
+}
+
+// routeClock remember when route was last changed by container event, so
+// resync working from older container list leave the route alone
+type routeClock struct {
+	m       sync.Mutex
+	changed map[string]time.Time
+}
+
+func (c *routeClock) touch(route string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.changed == nil {
+		c.changed = make(map[string]time.Time)
+	}
+	c.changed[route] = time.Now()
+}
+
+// changedSince check whether route changed by container event after t
+func (c *routeClock) changedSince(route string, t time.Time) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.changed[route].After(t)
+}
+
+// DrainNodes set weight of given nodes to zero so no new request routed to
+// them, it returns true when any node changed
+func DrainNodes(r *Route, lb docker.LoadBalancerURL) bool {
+	currNodes, ok := r.Upstream.Nodes.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	changed := false
+	for _, k := range lb {
+		if v, ok := currNodes[k]; ok && mathutil.SafeFloat(v) != 0 {
+			currNodes[k] = 0
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func logCorrection(ctx context.Context, route string, msg string, added []string, removed []string) {
+	log.Ctx(ctx).Info().
+		Str("route", route).
+		Strs("added", added).
+		Strs("removed", removed).
+		Msg(msg)
+}
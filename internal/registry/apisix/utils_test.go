@@ -6,6 +6,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/go-connections/nat"
+	"github.com/praswicaksono/turu/internal/docker"
 	"github.com/praswicaksono/turu/internal/registry/apisix"
 	"github.com/stretchr/testify/assert"
 )
@@ -125,3 +126,133 @@ func TestExtractLabelWithPrefix(t *testing.T) {
 		})
 	}
 }
+
+func TestConvergeNodes(t *testing.T) {
+	desired := &apisix.Route{
+		Upstream: &apisix.UpstreamDef{
+			Nodes: map[string]any{"web-1:80": 1, "web-3:80": 1},
+		},
+	}
+
+	table := TestTable{
+		test: func(data any) (any, error) {
+			r := data.(*apisix.Route)
+			added, removed := apisix.ConvergeNodes(r, desired, "host-a")
+			return []any{r, added, removed}, nil
+		},
+		assertion: map[string]TestAssertion{
+			"managed": {
+				data: func() any {
+					return &apisix.Route{
+						Labels: map[string]string{
+							"managed-by":          "turu",
+							"turu.owner.web-1:80": "host-a",
+							"turu.owner.web-2:80": "host-a",
+						},
+						Upstream: &apisix.UpstreamDef{
+							Nodes: map[string]any{"web-1:80": 0, "web-2:80": 1},
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.([]any)
+					route := res[0].(*apisix.Route)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"web-3:80"}, res[1])
+					assert.Equal(t, []string{"web-2:80"}, res[2])
+					assert.Equal(t, map[string]any{"web-1:80": 0, "web-3:80": 1}, route.Upstream.Nodes)
+					assert.Equal(t, map[string]string{
+						"managed-by":          "turu",
+						"turu.owner.web-1:80": "host-a",
+						"turu.owner.web-3:80": "host-a",
+					}, route.Labels)
+				},
+			},
+			"node_of_other_instance": {
+				data: func() any {
+					return &apisix.Route{
+						Labels: map[string]string{
+							"managed-by":          "turu",
+							"turu.owner.web-2:80": "host-b",
+						},
+						Upstream: &apisix.UpstreamDef{
+							Nodes: map[string]any{"web-1:80": 1, "web-2:80": 1, "web-4:80": 1},
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.([]any)
+					route := res[0].(*apisix.Route)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"web-3:80"}, res[1])
+					// web-4 has no owner, it was registered before instance set
+					assert.Nil(t, res[2])
+					assert.Equal(t, map[string]any{"web-1:80": 1, "web-2:80": 1, "web-3:80": 1, "web-4:80": 1}, route.Upstream.Nodes)
+				},
+			},
+			"unmanaged": {
+				data: func() any {
+					return &apisix.Route{
+						Upstream: &apisix.UpstreamDef{
+							Nodes: map[string]any{"web-2:80": 1},
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.([]any)
+					route := res[0].(*apisix.Route)
+					assert.NoError(t, err)
+					assert.Nil(t, res[1])
+					assert.Nil(t, res[2])
+					assert.Equal(t, map[string]any{"web-2:80": 1}, route.Upstream.Nodes)
+					assert.False(t, apisix.IsManagedRoute(*route))
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
+
+func TestDesiredRoutesMergeService(t *testing.T) {
+	cnt := func(name string) types.ContainerJSON {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Name: name,
+			},
+			Config: &container.Config{
+				Labels: map[string]string{
+					"turu.service":     "web",
+					"turu.apisix.host": "example.com",
+					"turu.apisix.uri":  "/*",
+				},
+				ExposedPorts: nat.PortSet{
+					nat.Port("80/tcp"): struct{}{},
+				},
+			},
+		}
+	}
+
+	routes := apisix.DesiredRoutes([]types.ContainerJSON{cnt("/web-1"), cnt("/web-2")})
+
+	assert.Equal(t, 1, len(routes))
+	assert.Equal(t, map[string]any{"web-1:80": 1, "web-2:80": 1}, routes["web"].Upstream.Nodes)
+}
+
+func TestDrainNodes(t *testing.T) {
+	r := &apisix.Route{
+		Upstream: &apisix.UpstreamDef{
+			Nodes: map[string]any{"web-1:80": float64(1), "web-2:80": float64(1)},
+		},
+	}
+
+	assert.True(t, apisix.DrainNodes(r, docker.LoadBalancerURL{"web-1:80"}))
+	assert.Equal(t, map[string]any{"web-1:80": 0, "web-2:80": float64(1)}, r.Upstream.Nodes)
+
+	// already drained
+	assert.False(t, apisix.DrainNodes(r, docker.LoadBalancerURL{"web-1:80"}))
+}
@@ -0,0 +1,192 @@
+package caddy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+const (
+	defaultEndpoint = "http://localhost:2019"
+	defaultServer   = "srv0"
+	defaultTimeout  = 5 * time.Second
+)
+
+// RegistryAdmin manage reverse proxy route through caddy admin api, route is
+// addressed by its @id so it stay stable regardless its position in routes
+type RegistryAdmin struct {
+	cfg *conf.Caddy
+	c   *http.Client
+}
+
+func NewRegistryAdmin(cfg *conf.Caddy) *RegistryAdmin {
+	return &RegistryAdmin{cfg: cfg}
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+func (p *RegistryAdmin) Construct(ctx context.Context) {
+	if p.c == nil {
+		p.c = &http.Client{Timeout: defaultTimeout}
+		if cfg := p.cfg; cfg != nil && cfg.Timeout > 0 {
+			p.c.Timeout = cfg.Timeout
+		}
+	}
+}
+
+func (p *RegistryAdmin) config() (endpoint string, server string) {
+	endpoint, server = defaultEndpoint, defaultServer
+
+	if cfg := p.cfg; cfg != nil {
+		if cfg.Endpoint != "" {
+			endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+		}
+		if cfg.Server != "" {
+			server = cfg.Server
+		}
+	}
+
+	return endpoint, server
+}
+
+// request call admin api and decode response into out, it returns false when
+// object not found
+func (p *RegistryAdmin) request(ctx context.Context, method string, path string, body any, out any) (bool, error) {
+	endpoint, _ := p.config()
+
+	var r io.Reader
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		r = bytes.NewReader(j)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, r)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.c.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if res.StatusCode >= 300 {
+		var ae adminError
+		_ = json.NewDecoder(res.Body).Decode(&ae)
+		return false, fmt.Errorf("caddy admin %s %s: %d %s", method, path, res.StatusCode, ae.Error)
+	}
+
+	if out != nil {
+		err = json.NewDecoder(res.Body).Decode(out)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// upstreamsPath return admin api path of reverse proxy handler upstreams, only
+// upstreams are written back so the rest of the route is left as is
+func upstreamsPath(id string, i int) string {
+	return fmt.Sprintf("/id/%s/handle/%d/upstreams", id, i)
+}
+
+func (p *RegistryAdmin) Register(ctx context.Context, c types.ContainerJSON) error {
+	newRoute := CreateRoute(c)
+
+	var currentRoute Route
+	found, err := p.request(ctx, http.MethodGet, "/id/"+newRoute.ID, nil, &currentRoute)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		_, server := p.config()
+		_, err = p.request(ctx, http.MethodPost, fmt.Sprintf("/config/apps/http/servers/%s/routes", server), newRoute, nil)
+		return err
+	}
+
+	// route exist without reverse proxy, append handler of turu
+	i := ReverseProxyIndex(&currentRoute)
+	if i < 0 {
+		_, err = p.request(ctx, http.MethodPost, "/id/"+newRoute.ID+"/handle", newRoute.Handle[0], nil)
+		return err
+	}
+
+	var upstreams []json.RawMessage
+	found, err = p.request(ctx, http.MethodGet, upstreamsPath(newRoute.ID, i), nil, &upstreams)
+	if err != nil {
+		return err
+	}
+
+	upstreams, changed, err := MergeUpstreams(upstreams, newRoute.Handle[0].Upstreams)
+	if err != nil || !changed {
+		return err
+	}
+
+	method := http.MethodPatch
+	if !found {
+		method = http.MethodPut
+	}
+	_, err = p.request(ctx, method, upstreamsPath(newRoute.ID, i), upstreams, nil)
+
+	return err
+}
+
+func (p *RegistryAdmin) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	name, service := docker.GetContainerOrServiceName(c)
+	id := RouteID(service)
+
+	var currentRoute Route
+	found, err := p.request(ctx, http.MethodGet, "/id/"+id, nil, &currentRoute)
+	if err != nil {
+		return err
+	}
+
+	i := ReverseProxyIndex(&currentRoute)
+	if !found || i < 0 {
+		return nil
+	}
+
+	var upstreams []json.RawMessage
+	_, err = p.request(ctx, http.MethodGet, upstreamsPath(id, i), nil, &upstreams)
+	if err != nil {
+		return err
+	}
+
+	upstreams, changed := RemoveUpstreams(upstreams, CreateUpstreams(name, c))
+	if !changed {
+		return nil
+	}
+
+	// if there is no upstream left, delete the route
+	if len(upstreams) == 0 {
+		_, err = p.request(ctx, http.MethodDelete, "/id/"+id, nil, nil)
+		return err
+	}
+
+	_, err = p.request(ctx, http.MethodPatch, upstreamsPath(id, i), upstreams, nil)
+
+	return err
+}
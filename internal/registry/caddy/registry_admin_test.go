@@ -0,0 +1,329 @@
+package caddy_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAdmin implement subset of caddy admin api used by turu, routes are kept
+// as plain json values so fields unknown to turu survive or not like in caddy
+type fakeAdmin struct {
+	m      sync.Mutex
+	routes []map[string]any
+}
+
+func (f *fakeAdmin) find(id string) int {
+	for i, r := range f.routes {
+		if r["@id"] == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// traverse return value at path segments below v
+func traverse(v any, segs []string) (any, bool) {
+	for _, seg := range segs {
+		switch x := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = x[seg]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i >= len(x) {
+				return nil, false
+			}
+			v = x[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func (f *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	var body any
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == "/config/apps/http/servers/web/routes" {
+		f.routes = append(f.routes, body.(map[string]any))
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/id/"), "/")
+	i := f.find(id)
+	if !strings.HasPrefix(r.URL.Path, "/id/") || i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unknown object ID"})
+		return
+	}
+
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(f.routes[i])
+		case http.MethodDelete:
+			f.routes = append(f.routes[:i], f.routes[i+1:]...)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	segs := strings.Split(rest, "/")
+	parent, ok := traverse(f.routes[i], segs[:len(segs)-1])
+	key := segs[len(segs)-1]
+	m, isMap := parent.(map[string]any)
+	if !ok || !isMap {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid traversal path"})
+		return
+	}
+
+	v, exist := m[key]
+	switch {
+	case r.Method == http.MethodGet && exist:
+		json.NewEncoder(w).Encode(v)
+	case r.Method == http.MethodPatch && exist, r.Method == http.MethodPut && !exist:
+		m[key] = body
+	case r.Method == http.MethodPost && exist:
+		m[key] = append(v.([]any), body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid traversal path"})
+	}
+}
+
+// upstreams return dial of reverse proxy upstreams of route
+func (f *fakeAdmin) upstreams(route int) []string {
+	var dials []string
+	for _, h := range f.routes[route]["handle"].([]any) {
+		h := h.(map[string]any)
+		if h["handler"] != "reverse_proxy" {
+			continue
+		}
+		for _, u := range h["upstreams"].([]any) {
+			dials = append(dials, u.(map[string]any)["dial"].(string))
+		}
+	}
+
+	return dials
+}
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is registry calls of single test case
+type scenario = func(ctx context.Context, r *caddy.RegistryAdmin) error
+
+// preloaded is scenario run against caddy already having route, if any
+type preloaded struct {
+	route string
+	run   scenario
+}
+
+// existingRoute is route of whoami configured outside of turu, with fields
+// turu does not know about
+const existingRoute = `{
+	"@id": "turu-whoami",
+	"group": "api",
+	"match": [{"host": ["example.com"]}],
+	"handle": [
+		{"handler": "headers", "response": {"set": {"X-Served-By": ["caddy"]}}},
+		{
+			"handler": "reverse_proxy",
+			"health_checks": {"active": {"uri": "/health"}},
+			"upstreams": [{"dial": "whoami-0:80", "max_requests": 100}]
+		}
+	],
+	"terminal": true
+}`
+
+func newContainer(name string) types.ContainerJSON {
+	return dockertest.Container{
+		Name: name,
+		Labels: map[string]string{
+			"turu.caddy.host": "example.com, www.example.com",
+			"turu.caddy.path": "/api/*",
+		},
+	}.Build()
+}
+
+func register(names ...string) scenario {
+	return func(ctx context.Context, r *caddy.RegistryAdmin) error {
+		for _, name := range names {
+			if err := r.Register(ctx, newContainer(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestRegistryAdmin(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			sc := data.(preloaded)
+
+			admin := &fakeAdmin{}
+			if sc.route != "" {
+				var route map[string]any
+				if err := json.Unmarshal([]byte(sc.route), &route); err != nil {
+					return nil, err
+				}
+				admin.routes = append(admin.routes, route)
+			}
+
+			srv := httptest.NewServer(admin)
+			defer srv.Close()
+
+			ctx := context.Background()
+			r := caddy.NewRegistryAdmin(&conf.Caddy{
+				Endpoint: srv.URL,
+				Server:   "web",
+			})
+			r.Construct(ctx)
+
+			err := sc.run(ctx, r)
+			return admin, err
+		},
+		assertion: map[string]TestAssertion{
+			"register_merge_route": {
+				data: func() any {
+					return preloaded{run: register("whoami-1", "whoami-2")}
+				},
+				expectation: func(obj any, err error) {
+					admin := obj.(*fakeAdmin)
+					assert.NoError(t, err)
+					assert.Len(t, admin.routes, 1)
+
+					b, err := json.Marshal(admin.routes[0])
+					assert.NoError(t, err)
+					assert.JSONEq(t, `{
+						"@id": "turu-whoami",
+						"match": [{"host": ["example.com", "www.example.com"], "path": ["/api/*"]}],
+						"handle": [{"handler": "reverse_proxy", "upstreams": [{"dial": "whoami-1:80"}, {"dial": "whoami-2:80"}]}],
+						"terminal": true
+					}`, string(b))
+				},
+			},
+			"register_keep_existing_route": {
+				data: func() any {
+					return preloaded{route: existingRoute, run: register("whoami-1")}
+				},
+				expectation: func(obj any, err error) {
+					admin := obj.(*fakeAdmin)
+					assert.NoError(t, err)
+
+					b, err := json.Marshal(admin.routes[0])
+					assert.NoError(t, err)
+					assert.JSONEq(t, strings.Replace(existingRoute,
+						`[{"dial": "whoami-0:80", "max_requests": 100}]`,
+						`[{"dial": "whoami-0:80", "max_requests": 100}, {"dial": "whoami-1:80"}]`, 1), string(b))
+				},
+			},
+			"register_without_reverse_proxy": {
+				data: func() any {
+					return preloaded{
+						route: `{"@id": "turu-whoami", "handle": [{"handler": "headers"}]}`,
+						run:   register("whoami-1"),
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-1:80"}, obj.(*fakeAdmin).upstreams(0))
+				},
+			},
+			"deregister_upstream": {
+				data: func() any {
+					return preloaded{run: func(ctx context.Context, r *caddy.RegistryAdmin) error {
+						if err := register("whoami-1", "whoami-2")(ctx, r); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, newContainer("whoami-1"))
+					}}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-2:80"}, obj.(*fakeAdmin).upstreams(0))
+				},
+			},
+			"deregister_keep_existing_route": {
+				data: func() any {
+					return preloaded{route: existingRoute, run: func(ctx context.Context, r *caddy.RegistryAdmin) error {
+						if err := register("whoami-1")(ctx, r); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, newContainer("whoami-1"))
+					}}
+				},
+				expectation: func(obj any, err error) {
+					admin := obj.(*fakeAdmin)
+					assert.NoError(t, err)
+
+					b, err := json.Marshal(admin.routes[0])
+					assert.NoError(t, err)
+					assert.JSONEq(t, existingRoute, string(b))
+				},
+			},
+			"deregister_last_upstream": {
+				data: func() any {
+					return preloaded{run: func(ctx context.Context, r *caddy.RegistryAdmin) error {
+						if err := register("whoami-1")(ctx, r); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, newContainer("whoami-1"))
+					}}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj.(*fakeAdmin).routes)
+				},
+			},
+			"deregister_unknown": {
+				data: func() any {
+					return preloaded{run: func(ctx context.Context, r *caddy.RegistryAdmin) error {
+						return r.Deregister(ctx, newContainer("whoami-1"))
+					}}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package caddy
+
+// Route is caddy http route, only fields managed by turu are defined. It is
+// only written when the route is created, existing route is updated through
+// its upstreams path so fields unknown to turu are kept.
+type Route struct {
+	ID       string    `json:"@id,omitempty"`
+	Match    []Match   `json:"match,omitempty"`
+	Handle   []Handler `json:"handle"`
+	Terminal bool      `json:"terminal,omitempty"`
+}
+
+type Match struct {
+	Host []string `json:"host,omitempty"`
+	Path []string `json:"path,omitempty"`
+}
+
+type Handler struct {
+	Handler   string     `json:"handler"`
+	Upstreams []Upstream `json:"upstreams,omitempty"`
+}
+
+type Upstream struct {
+	Dial string `json:"dial"`
+}
@@ -0,0 +1,119 @@
+package caddy
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+var (
+	LABEL_TURU_CADDY_HOST = "turu.caddy.host"
+	LABEL_TURU_CADDY_PATH = "turu.caddy.path"
+)
+
+// RouteID return caddy @id of service route
+func RouteID(service string) string {
+	return "turu-" + service
+}
+
+// CreateRoute create reverse proxy route of container, route is identified by
+// its service so every container of the service share the same route
+func CreateRoute(cnt types.ContainerJSON) *Route {
+	name, service := docker.GetContainerOrServiceName(cnt)
+	labels := cnt.Config.Labels
+
+	r := &Route{
+		ID: RouteID(service),
+		Handle: []Handler{
+			{
+				Handler:   "reverse_proxy",
+				Upstreams: CreateUpstreams(name, cnt),
+			},
+		},
+		Terminal: true,
+	}
+
+	m := Match{
+		Host: splitLabel(labels[LABEL_TURU_CADDY_HOST]),
+		Path: splitLabel(labels[LABEL_TURU_CADDY_PATH]),
+	}
+	if len(m.Host) > 0 || len(m.Path) > 0 {
+		r.Match = []Match{m}
+	}
+
+	return r
+}
+
+// CreateUpstreams create upstream for every exposed port of container
+func CreateUpstreams(name string, cnt types.ContainerJSON) []Upstream {
+	var upstreams []Upstream
+	for _, v := range docker.GetLoadBalancerURL(name, cnt) {
+		if v == "" {
+			continue
+		}
+		upstreams = append(upstreams, Upstream{Dial: v})
+	}
+
+	return upstreams
+}
+
+// ReverseProxyIndex return index of reverse proxy handler in route, it returns
+// -1 when there is none
+func ReverseProxyIndex(r *Route) int {
+	return slices.IndexFunc(r.Handle, func(h Handler) bool {
+		return h.Handler == "reverse_proxy"
+	})
+}
+
+// MergeUpstreams add upstreams missing from current upstreams of reverse proxy
+// handler, current upstreams are kept as is including fields not managed by
+// turu. It returns false when nothing changed.
+func MergeUpstreams(current []json.RawMessage, upstreams []Upstream) ([]json.RawMessage, bool, error) {
+	changed := false
+	for _, u := range upstreams {
+		if slices.ContainsFunc(current, func(raw json.RawMessage) bool { return dial(raw) == u.Dial }) {
+			continue
+		}
+
+		j, err := json.Marshal(u)
+		if err != nil {
+			return nil, false, err
+		}
+		current = append(current, j)
+		changed = true
+	}
+
+	return current, changed, nil
+}
+
+// RemoveUpstreams remove upstreams from current upstreams of reverse proxy
+// handler, it returns false when nothing changed
+func RemoveUpstreams(current []json.RawMessage, upstreams []Upstream) ([]json.RawMessage, bool) {
+	n := len(current)
+	current = slices.DeleteFunc(current, func(raw json.RawMessage) bool {
+		return slices.ContainsFunc(upstreams, func(u Upstream) bool { return dial(raw) == u.Dial })
+	})
+
+	return current, len(current) != n
+}
+
+func dial(raw json.RawMessage) string {
+	var u Upstream
+	_ = json.Unmarshal(raw, &u)
+
+	return u.Dial
+}
+
+func splitLabel(v string) []string {
+	var res []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
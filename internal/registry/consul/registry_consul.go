@@ -0,0 +1,98 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/hashicorp/consul/api"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/rs/zerolog/log"
+)
+
+type RegistryConsul struct {
+	cfg *conf.Consul
+	c   *api.Client
+}
+
+func NewRegistryConsul(cfg *conf.Consul) *RegistryConsul {
+	return &RegistryConsul{cfg: cfg}
+}
+
+func (p *RegistryConsul) createConsulClient() *api.Client {
+	cfg := p.cfg
+	if cfg == nil {
+		log.Fatal().Msg("consul is not configured")
+	}
+
+	cnf := api.DefaultConfig()
+
+	if cfg.Address != "" {
+		cnf.Address = cfg.Address
+	}
+
+	if cfg.Scheme != "" {
+		cnf.Scheme = cfg.Scheme
+	}
+
+	cnf.Datacenter = cfg.Datacenter
+	cnf.Token = cfg.Token
+
+	if cfg.TLS != nil {
+		cnf.TLSConfig = api.TLSConfig{
+			CAFile:   cfg.TLS.CA,
+			CertFile: cfg.TLS.Cert,
+			KeyFile:  cfg.TLS.Key,
+		}
+	}
+
+	cli, err := api.NewClient(cnf)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	return cli
+}
+
+func (p *RegistryConsul) Construct(ctx context.Context) {
+	if p.c == nil {
+		p.c = p.createConsulClient()
+	}
+}
+
+func (p *RegistryConsul) Register(ctx context.Context, c types.ContainerJSON) error {
+	regs, err := CreateServices(c)
+	if err != nil {
+		return err
+	}
+
+	for _, reg := range regs {
+		err = p.c.Agent().ServiceRegisterOpts(reg, api.ServiceRegisterOpts{}.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *RegistryConsul) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	name, _ := docker.GetContainerOrServiceName(c)
+
+	for port := range c.Config.ExposedPorts {
+		err := p.c.Agent().ServiceDeregisterOpts(ServiceID(name, port.Port()), (&api.QueryOptions{}).WithContext(ctx))
+
+		var serr api.StatusError
+		if errors.As(err, &serr) && serr.Code == http.StatusNotFound {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package consul_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/hashicorp/consul/api"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/consul"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAgent implement subset of consul agent http api used by turu
+type fakeAgent struct {
+	m        sync.Mutex
+	services map[string]api.AgentServiceRegistration
+	token    string
+}
+
+func (f *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.token = r.Header.Get("X-Consul-Token")
+
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var reg api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.services[reg.ID] = reg
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		if _, ok := f.services[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.services, id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is registry calls of single test case
+type scenario = func(ctx context.Context, r *consul.RegistryConsul) error
+
+func newContainer() types.ContainerJSON {
+	return dockertest.Container{
+		Labels: map[string]string{
+			"turu.consul.tags":       "web, v1",
+			"turu.consul.meta.team":  "platform",
+			"turu.consul.check.http": "/health",
+		},
+	}.Build()
+}
+
+func TestRegistryConsul(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			agent := &fakeAgent{services: map[string]api.AgentServiceRegistration{}}
+			srv := httptest.NewServer(agent)
+			defer srv.Close()
+
+			ctx := context.Background()
+			r := consul.NewRegistryConsul(&conf.Consul{
+				Address: srv.URL,
+				Token:   "secret",
+			})
+			r.Construct(ctx)
+
+			err := data.(scenario)(ctx, r)
+			return agent, err
+		},
+		assertion: map[string]TestAssertion{
+			"register": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *consul.RegistryConsul) error {
+						return r.Register(ctx, newContainer())
+					})
+				},
+				expectation: func(obj any, err error) {
+					agent := obj.(*fakeAgent)
+					assert.NoError(t, err)
+					assert.Equal(t, "secret", agent.token)
+
+					reg, ok := agent.services["whoami-1-80"]
+					assert.True(t, ok)
+					assert.Equal(t, "whoami", reg.Name)
+					assert.Equal(t, "whoami-1", reg.Address)
+					assert.Equal(t, 80, reg.Port)
+					assert.Equal(t, []string{"web", "v1"}, reg.Tags)
+					assert.Equal(t, map[string]string{"team": "platform"}, reg.Meta)
+					assert.Equal(t, "http://whoami-1:80/health", reg.Check.HTTP)
+				},
+			},
+			"deregister": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *consul.RegistryConsul) error {
+						if err := r.Register(ctx, newContainer()); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, newContainer())
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj.(*fakeAgent).services)
+				},
+			},
+			"deregister_unknown": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *consul.RegistryConsul) error {
+						return r.Deregister(ctx, newContainer())
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package consul
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/hashicorp/consul/api"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+var (
+	LABEL_TURU_CONSUL_TAGS           = "turu.consul.tags"
+	LABEL_TURU_CONSUL_META_PREFIX    = "turu.consul.meta."
+	LABEL_TURU_CONSUL_CHECK_HTTP     = "turu.consul.check.http"
+	LABEL_TURU_CONSUL_CHECK_TCP      = "turu.consul.check.tcp"
+	LABEL_TURU_CONSUL_CHECK_INTERVAL = "turu.consul.check.interval"
+	LABEL_TURU_CONSUL_CHECK_TIMEOUT  = "turu.consul.check.timeout"
+)
+
+// CreateServices create consul service registration for every exposed port of
+// container, service id is unique per container and port
+func CreateServices(cnt types.ContainerJSON) ([]*api.AgentServiceRegistration, error) {
+	name, service := docker.GetContainerOrServiceName(cnt)
+	labels := cnt.Config.Labels
+
+	var tags []string
+	for _, v := range strings.Split(labels[LABEL_TURU_CONSUL_TAGS], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			tags = append(tags, v)
+		}
+	}
+
+	meta := make(map[string]string)
+	for k, v := range labels {
+		if strings.HasPrefix(k, LABEL_TURU_CONSUL_META_PREFIX) {
+			meta[strings.TrimPrefix(k, LABEL_TURU_CONSUL_META_PREFIX)] = v
+		}
+	}
+
+	regs := make([]*api.AgentServiceRegistration, 0, len(cnt.Config.ExposedPorts))
+	for p := range cnt.Config.ExposedPorts {
+		port, err := strconv.Atoi(p.Port())
+		if err != nil {
+			return nil, err
+		}
+
+		regs = append(regs, &api.AgentServiceRegistration{
+			ID:      ServiceID(name, p.Port()),
+			Name:    service,
+			Address: name,
+			Port:    port,
+			Tags:    tags,
+			Meta:    meta,
+			Check:   createCheck(labels, name, p.Port()),
+		})
+	}
+
+	return regs, nil
+}
+
+func ServiceID(name string, port string) string {
+	return fmt.Sprintf("%s-%s", name, port)
+}
+
+func createCheck(labels map[string]string, name string, port string) *api.AgentServiceCheck {
+	interval := "10s"
+	if v, ok := labels[LABEL_TURU_CONSUL_CHECK_INTERVAL]; ok {
+		interval = v
+	}
+
+	timeout := "5s"
+	if v, ok := labels[LABEL_TURU_CONSUL_CHECK_TIMEOUT]; ok {
+		timeout = v
+	}
+
+	if path, ok := labels[LABEL_TURU_CONSUL_CHECK_HTTP]; ok {
+		return &api.AgentServiceCheck{
+			HTTP:     fmt.Sprintf("http://%s:%s%s", name, port, path),
+			Interval: interval,
+			Timeout:  timeout,
+		}
+	}
+
+	if labels[LABEL_TURU_CONSUL_CHECK_TCP] == "true" {
+		return &api.AgentServiceCheck{
+			TCP:      fmt.Sprintf("%s:%s", name, port),
+			Interval: interval,
+			Timeout:  timeout,
+		}
+	}
+
+	return nil
+}
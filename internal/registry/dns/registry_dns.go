@@ -0,0 +1,147 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/miekg/dns"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultZone = "turu."
+	defaultTTL  = 5
+)
+
+// RegistryDNS serve registered containers through embedded authoritative dns
+// server, A and AAAA answer container ips and SRV answer exposed ports
+type RegistryDNS struct {
+	cfg  *conf.DNS
+	zone *Zone
+}
+
+func NewRegistryDNS(cfg *conf.DNS) *RegistryDNS {
+	return &RegistryDNS{cfg: cfg}
+}
+
+func (p *RegistryDNS) Construct(ctx context.Context) {
+	if p.zone != nil {
+		return
+	}
+
+	cfg := p.cfg
+	if cfg == nil || cfg.Listen == "" {
+		log.Fatal().Msg("dns.listen could not be empty")
+	}
+
+	origin, ttl := cfg.Zone, cfg.TTL
+	if origin == "" {
+		origin = defaultZone
+	}
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+
+	p.zone = NewZone(origin, ttl)
+
+	// bind before serving so unavailable address stop turu on startup
+	pc, err := net.ListenPacket("udp", cfg.Listen)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	l, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	for network, srv := range map[string]*dns.Server{
+		"udp": {PacketConn: pc, Handler: p},
+		"tcp": {Listener: l, Handler: p},
+	} {
+		go func() {
+			log.Info().Str("addr", cfg.Listen).Str("net", network).Str("zone", p.zone.Origin).Msg("serving dns")
+			err := srv.ActivateAndServe()
+			if err != nil {
+				log.Error().Err(err).Str("net", network).Msg("dns server stopped")
+			}
+		}()
+
+		go func() {
+			<-ctx.Done()
+			srv.Shutdown()
+		}()
+	}
+}
+
+func (p *RegistryDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	for _, q := range r.Question {
+		if !dns.IsSubDomain(p.zone.Origin, strings.ToLower(q.Name)) {
+			m.Authoritative = false
+			m.Rcode = dns.RcodeRefused
+			break
+		}
+
+		answer, extra, exist := p.zone.Answer(q)
+		if !exist && strings.EqualFold(q.Name, p.zone.Origin) {
+			exist = true
+			if q.Qtype == dns.TypeSOA {
+				answer = append(answer, p.zone.SOA())
+			}
+		}
+		if !exist {
+			m.Rcode = dns.RcodeNameError
+		}
+
+		m.Answer = append(m.Answer, answer...)
+		m.Extra = append(m.Extra, extra...)
+	}
+
+	// negative answer carry soa so resolver know how long to cache it
+	if m.Rcode != dns.RcodeRefused && len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, p.zone.SOA())
+	}
+
+	err := w.WriteMsg(m)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write dns response")
+	}
+}
+
+func (p *RegistryDNS) Register(ctx context.Context, c types.ContainerJSON) error {
+	name, service := docker.GetContainerOrServiceName(c)
+
+	ipv4, ipv6 := docker.GetContainerIPs(c)
+	if len(ipv4) == 0 && len(ipv6) == 0 {
+		return errors.New("container has no ip address, could not register to dns")
+	}
+
+	i := Instance{
+		Name: name,
+		IPv4: ipv4,
+		IPv6: ipv6,
+	}
+	for port := range c.Config.ExposedPorts {
+		i.Ports = append(i.Ports, Port{Port: uint16(port.Int()), Proto: port.Proto()})
+	}
+
+	p.zone.Set(service, c.ID, i)
+
+	return nil
+}
+
+func (p *RegistryDNS) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	_, service := docker.GetContainerOrServiceName(c)
+
+	p.zone.Delete(service, c.ID)
+
+	return nil
+}
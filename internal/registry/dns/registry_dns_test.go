@@ -0,0 +1,174 @@
+package dns_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/miekg/dns"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	turudns "github.com/praswicaksono/turu/internal/registry/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// question is asked after postgres-1 and postgres-2 registered, both are
+// deregistered first when deregister is set
+type question struct {
+	name       string
+	qtype      uint16
+	deregister bool
+}
+
+func newContainer(name string, ip string) types.ContainerJSON {
+	return dockertest.Container{
+		Name:    name,
+		Service: "postgres",
+		Ports:   []string{"5432/tcp"},
+		IP:      ip,
+		IPv6:    "fd00::2",
+	}.Build()
+}
+
+func TestRegistryDNS(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			q := data.(question)
+
+			ctx := context.Background()
+			r := turudns.NewRegistryDNS(&conf.DNS{Listen: "127.0.0.1:0", Zone: "svc.local"})
+			r.Construct(ctx)
+
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			srv := &dns.Server{PacketConn: pc, Handler: r}
+			go srv.ActivateAndServe()
+			defer srv.Shutdown()
+
+			cnts := []types.ContainerJSON{
+				newContainer("postgres-1", "172.18.0.2"),
+				newContainer("postgres-2", "172.18.0.3"),
+			}
+			for _, c := range cnts {
+				if err := r.Register(ctx, c); err != nil {
+					return nil, err
+				}
+			}
+			if q.deregister {
+				// second deregister of postgres-2 is not an error
+				for _, c := range append(cnts, cnts[1]) {
+					if err := r.Deregister(ctx, c); err != nil {
+						return nil, err
+					}
+				}
+			}
+
+			m := new(dns.Msg)
+			m.SetQuestion(q.name, q.qtype)
+
+			return dns.Exchange(m, pc.LocalAddr().String())
+		},
+		assertion: map[string]TestAssertion{
+			"a_service": {
+				data: func() any {
+					return question{name: "postgres.svc.local.", qtype: dns.TypeA}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(*dns.Msg)
+					assert.NoError(t, err)
+					assert.Equal(t, dns.RcodeSuccess, res.Rcode)
+					assert.True(t, res.Authoritative)
+					assert.Len(t, res.Answer, 2)
+					assert.Equal(t, "172.18.0.2", res.Answer[0].(*dns.A).A.String())
+					assert.Equal(t, "172.18.0.3", res.Answer[1].(*dns.A).A.String())
+				},
+			},
+			"aaaa_container": {
+				data: func() any {
+					return question{name: "postgres-1.postgres.svc.local.", qtype: dns.TypeAAAA}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(*dns.Msg)
+					assert.NoError(t, err)
+					assert.Len(t, res.Answer, 1)
+					assert.Equal(t, "fd00::2", res.Answer[0].(*dns.AAAA).AAAA.String())
+				},
+			},
+			"srv_service": {
+				data: func() any {
+					return question{name: "postgres.svc.local.", qtype: dns.TypeSRV}
+				},
+				expectation: expectSRV(t),
+			},
+			"srv_rfc2782": {
+				data: func() any {
+					return question{name: "_postgres._tcp.svc.local.", qtype: dns.TypeSRV}
+				},
+				expectation: expectSRV(t),
+			},
+			"srv_other_protocol": {
+				data: func() any {
+					return question{name: "_postgres._udp.svc.local.", qtype: dns.TypeSRV}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(*dns.Msg)
+					assert.NoError(t, err)
+					assert.Equal(t, dns.RcodeSuccess, res.Rcode)
+					assert.Empty(t, res.Answer)
+				},
+			},
+			"outside_zone": {
+				data: func() any {
+					return question{name: "example.com.", qtype: dns.TypeA}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, dns.RcodeRefused, obj.(*dns.Msg).Rcode)
+				},
+			},
+			"deregistered": {
+				data: func() any {
+					return question{name: "postgres.svc.local.", qtype: dns.TypeA, deregister: true}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(*dns.Msg)
+					assert.NoError(t, err)
+					assert.Equal(t, dns.RcodeNameError, res.Rcode)
+					assert.Len(t, res.Ns, 1)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
+
+func expectSRV(t *testing.T) func(any, error) {
+	return func(obj any, err error) {
+		res := obj.(*dns.Msg)
+		assert.NoError(t, err)
+		assert.Len(t, res.Answer, 2)
+		srv := res.Answer[0].(*dns.SRV)
+		assert.Equal(t, uint16(5432), srv.Port)
+		assert.Equal(t, "postgres-1.postgres.svc.local.", srv.Target)
+		// target address is sent as additional record
+		assert.NotEmpty(t, res.Extra)
+	}
+}
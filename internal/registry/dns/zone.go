@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Instance is dns data of single registered container
+type Instance struct {
+	Name  string
+	IPv4  []string
+	IPv6  []string
+	Ports []Port
+}
+
+type Port struct {
+	Port  uint16
+	Proto string
+}
+
+// Zone answer query of registered services, service is served as
+// <service>.<zone> and every container as <container>.<service>.<zone>
+type Zone struct {
+	Origin string
+	TTL    uint32
+
+	m        sync.RWMutex
+	services map[string]map[string]Instance
+}
+
+func NewZone(origin string, ttl uint32) *Zone {
+	return &Zone{
+		Origin:   dns.Fqdn(strings.ToLower(origin)),
+		TTL:      ttl,
+		services: make(map[string]map[string]Instance),
+	}
+}
+
+// Set add or replace instance of service identified by container id
+func (z *Zone) Set(service string, id string, i Instance) {
+	z.m.Lock()
+	defer z.m.Unlock()
+
+	service = strings.ToLower(service)
+	if _, ok := z.services[service]; !ok {
+		z.services[service] = make(map[string]Instance)
+	}
+	z.services[service][id] = i
+}
+
+// Delete remove instance of service, it returns false when the instance does
+// not exist
+func (z *Zone) Delete(service string, id string) bool {
+	z.m.Lock()
+	defer z.m.Unlock()
+
+	service = strings.ToLower(service)
+	if _, ok := z.services[service][id]; !ok {
+		return false
+	}
+
+	delete(z.services[service], id)
+	if len(z.services[service]) == 0 {
+		delete(z.services, service)
+	}
+
+	return true
+}
+
+// instances return instances of service sorted by name so answer is stable
+func (z *Zone) instances(service string) []Instance {
+	var res []Instance
+	for _, i := range z.services[service] {
+		res = append(res, i)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Name < res[b].Name })
+
+	return res
+}
+
+// lookup split query name into service and optional container, SRV query in
+// RFC 2782 form _<service>._<proto>.<zone> is supported as well
+func (z *Zone) lookup(name string) (service string, container string, proto string, ok bool) {
+	name = strings.ToLower(name)
+	if !dns.IsSubDomain(z.Origin, name) || name == z.Origin {
+		return "", "", "", false
+	}
+
+	labels := dns.SplitDomainName(strings.TrimSuffix(name, "."+z.Origin))
+	switch {
+	case len(labels) == 2 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_"):
+		return strings.TrimPrefix(labels[0], "_"), "", strings.TrimPrefix(labels[1], "_"), true
+	case len(labels) == 1:
+		return labels[0], "", "", true
+	case len(labels) == 2:
+		return labels[1], labels[0], "", true
+	}
+
+	return "", "", "", false
+}
+
+// Answer return answer and additional records of question, exist is false
+// when the name is not in the zone
+func (z *Zone) Answer(q dns.Question) (answer []dns.RR, extra []dns.RR, exist bool) {
+	service, container, proto, ok := z.lookup(q.Name)
+	if !ok {
+		return nil, nil, false
+	}
+
+	z.m.RLock()
+	defer z.m.RUnlock()
+
+	instances := z.instances(service)
+	if container != "" {
+		instances = filterInstance(instances, container)
+	}
+	if len(instances) == 0 {
+		return nil, nil, false
+	}
+
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeANY:
+		for _, i := range instances {
+			answer = append(answer, z.address(q.Name, i, q.Qtype)...)
+		}
+	case dns.TypeSRV:
+		// srv of single container is not meaningful
+		if container != "" {
+			break
+		}
+		for _, i := range instances {
+			target := z.instanceName(service, i)
+			for _, p := range i.Ports {
+				if proto != "" && p.Proto != proto {
+					continue
+				}
+				answer = append(answer, &dns.SRV{
+					Hdr:      z.header(q.Name, dns.TypeSRV),
+					Priority: 10,
+					Weight:   10,
+					Port:     p.Port,
+					Target:   target,
+				})
+			}
+			extra = append(extra, z.address(target, i, dns.TypeANY)...)
+		}
+	}
+
+	return answer, extra, true
+}
+
+// SOA return start of authority of the zone, used in negative answer
+func (z *Zone) SOA() dns.RR {
+	return &dns.SOA{
+		Hdr:     z.header(z.Origin, dns.TypeSOA),
+		Ns:      "ns." + z.Origin,
+		Mbox:    "hostmaster." + z.Origin,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  z.TTL,
+	}
+}
+
+func (z *Zone) instanceName(service string, i Instance) string {
+	return dns.Fqdn(strings.ToLower(i.Name) + "." + service + "." + z.Origin)
+}
+
+func (z *Zone) address(name string, i Instance, qtype uint16) []dns.RR {
+	var rr []dns.RR
+
+	if qtype == dns.TypeA || qtype == dns.TypeANY {
+		for _, ip := range i.IPv4 {
+			rr = append(rr, &dns.A{Hdr: z.header(name, dns.TypeA), A: net.ParseIP(ip)})
+		}
+	}
+
+	if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
+		for _, ip := range i.IPv6 {
+			rr = append(rr, &dns.AAAA{Hdr: z.header(name, dns.TypeAAAA), AAAA: net.ParseIP(ip)})
+		}
+	}
+
+	return rr
+}
+
+func (z *Zone) header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: z.TTL}
+}
+
+func filterInstance(instances []Instance, name string) []Instance {
+	for _, i := range instances {
+		if strings.EqualFold(i.Name, name) {
+			return []Instance{i}
+		}
+	}
+
+	return nil
+}
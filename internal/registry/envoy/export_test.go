@@ -0,0 +1,8 @@
+package envoy
+
+import "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+
+// Snapshot return snapshot currently served to envoy
+func (p *RegistryXDS) Snapshot() (cache.ResourceSnapshot, error) {
+	return p.cache.GetSnapshot(snapshotKey)
+}
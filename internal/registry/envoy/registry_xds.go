@@ -0,0 +1,248 @@
+package envoy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	xdslog "github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/gookit/goutil"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+var (
+	LABEL_TURU_ENVOY_DOMAINS = "turu.envoy.domains"
+	LABEL_TURU_ENVOY_PREFIX  = "turu.envoy.prefix"
+)
+
+// snapshotKey is shared by every envoy node, all of them receive the same snapshot
+const snapshotKey = "turu"
+
+type nodeHash struct{}
+
+func (nodeHash) ID(node *core.Node) string {
+	return snapshotKey
+}
+
+// RegistryXDS serve clusters and endpoints of registered containers to envoy
+// through xds, every change push new snapshot version to connected envoy
+type RegistryXDS struct {
+	cfg      *conf.EnvoyXDS
+	m        *sync.Mutex
+	cache    cache.SnapshotCache
+	services map[string]*Service
+	version  uint64
+}
+
+func NewRegistryXDS(cfg *conf.EnvoyXDS) *RegistryXDS {
+	return &RegistryXDS{cfg: cfg}
+}
+
+func (p *RegistryXDS) Construct(ctx context.Context) {
+	if p.cache != nil {
+		return
+	}
+
+	cfg := p.cfg
+	if cfg == nil {
+		log.Fatal().Msg("envoy-xds is not configured")
+	}
+
+	p.m = &sync.Mutex{}
+	p.services = make(map[string]*Service)
+	p.cache = cache.NewSnapshotCache(true, nodeHash{}, xdslog.LoggerFuncs{
+		DebugFunc: func(format string, args ...any) { log.Debug().Msgf(format, args...) },
+		InfoFunc:  func(format string, args ...any) { log.Info().Msgf(format, args...) },
+		WarnFunc:  func(format string, args ...any) { log.Warn().Msgf(format, args...) },
+		ErrorFunc: func(format string, args ...any) { log.Error().Msgf(format, args...) },
+	})
+
+	err := p.push(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	lis, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	srv := server.NewServer(ctx, p.cache, nil)
+	gs := grpc.NewServer()
+	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(gs, srv)
+	clusterservice.RegisterClusterDiscoveryServiceServer(gs, srv)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(gs, srv)
+	listenerservice.RegisterListenerDiscoveryServiceServer(gs, srv)
+	routeservice.RegisterRouteDiscoveryServiceServer(gs, srv)
+
+	go func() {
+		log.Info().Str("addr", cfg.Listen).Msg("serving envoy xds")
+		err := gs.Serve(lis)
+		if err != nil {
+			log.Error().Err(err).Msg("envoy xds server stopped")
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		gs.Stop()
+	}()
+}
+
+// push set new snapshot version built from current services
+func (p *RegistryXDS) push(ctx context.Context) error {
+	p.version++
+	version := strconv.FormatUint(p.version, 10)
+
+	snapshot, err := CreateSnapshot(version, p.services, p.cfg.ListenerPort)
+	if err != nil {
+		return err
+	}
+
+	err = snapshot.Consistent()
+	if err != nil {
+		return err
+	}
+
+	err = ValidateSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = p.cache.SetSnapshot(ctx, snapshotKey, snapshot)
+	if err != nil {
+		return err
+	}
+
+	log.Ctx(ctx).Debug().Str("version", version).Msg("envoy snapshot updated")
+
+	return nil
+}
+
+// restore put back service state before failed push, nil prev remove the
+// service
+func (p *RegistryXDS) restore(service string, prev *Service) {
+	if prev == nil {
+		delete(p.services, service)
+		return
+	}
+
+	p.services[service] = prev
+}
+
+func (p *RegistryXDS) Register(ctx context.Context, c types.ContainerJSON) error {
+	_, service := docker.GetContainerOrServiceName(c)
+
+	ipv4, _ := docker.GetContainerIPs(c)
+	if len(ipv4) == 0 {
+		return errors.New("container has no ip address, could not register to envoy")
+	}
+
+	var endpoints []Endpoint
+	for port := range c.Config.ExposedPorts {
+		endpoints = append(endpoints, Endpoint{
+			Address: ipv4[0],
+			Port:    uint32(port.Int()),
+		})
+	}
+
+	domains := []string{service}
+	if v, ok := c.Config.Labels[LABEL_TURU_ENVOY_DOMAINS]; ok {
+		domains = nil
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d != "" && !slices.Contains(domains, d) {
+				domains = append(domains, d)
+			}
+		}
+	}
+
+	prefix := "/"
+	if v, ok := c.Config.Labels[LABEL_TURU_ENVOY_PREFIX]; ok {
+		prefix = v
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// envoy reject the whole route configuration when domain is duplicated
+	for name, s := range p.services {
+		if name == service {
+			continue
+		}
+		for _, d := range domains {
+			if slices.Contains(s.Domains, d) {
+				return fmt.Errorf("domain %s is already used by service %s", d, name)
+			}
+		}
+	}
+
+	// service and virtual host follow the labels of the latest container
+	prev := p.services[service]
+	s := &Service{
+		Name:      service,
+		Domains:   domains,
+		Prefix:    prefix,
+		Endpoints: make(map[string][]Endpoint),
+	}
+	if prev != nil {
+		maps.Copy(s.Endpoints, prev.Endpoints)
+	}
+	s.Endpoints[c.ID] = endpoints
+	p.services[service] = s
+
+	err := p.push(ctx)
+	if err != nil {
+		p.restore(service, prev)
+		return err
+	}
+
+	return nil
+}
+
+func (p *RegistryXDS) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	_, service := docker.GetContainerOrServiceName(c)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	prev, ok := p.services[service]
+
+	if !ok || !goutil.Contains(prev.Endpoints, c.ID) {
+		return nil
+	}
+
+	s := *prev
+	s.Endpoints = maps.Clone(prev.Endpoints)
+	delete(s.Endpoints, c.ID)
+	if len(s.Endpoints) == 0 {
+		delete(p.services, service)
+	} else {
+		p.services[service] = &s
+	}
+
+	err := p.push(ctx)
+	if err != nil {
+		p.restore(service, prev)
+		return err
+	}
+
+	return nil
+}
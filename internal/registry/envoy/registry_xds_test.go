@@ -0,0 +1,196 @@
+package envoy_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/envoy"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is registry calls of single test case
+type scenario = func(ctx context.Context, r *envoy.RegistryXDS) error
+
+// result is snapshot served to envoy after scenario run
+type result struct {
+	version      string
+	virtualHosts map[string]*route.VirtualHost
+	endpoints    map[string][]string
+}
+
+func newContainer(name string, ip string, labels map[string]string) types.ContainerJSON {
+	return dockertest.Container{Name: name, IP: ip, Labels: labels}.Build()
+}
+
+func registerAll(ctx context.Context, r *envoy.RegistryXDS, containers ...types.ContainerJSON) error {
+	for _, c := range containers {
+		if err := r.Register(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRegistryXDS(t *testing.T) {
+	whoami1 := newContainer("whoami-1", "172.18.0.2", map[string]string{"turu.envoy.domains": "example.com"})
+	whoami2 := newContainer("whoami-2", "172.18.0.3", map[string]string{"turu.envoy.domains": "example.com"})
+
+	table := TestTable{
+		test: func(data any) (any, error) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			r := envoy.NewRegistryXDS(&conf.EnvoyXDS{Listen: "127.0.0.1:0", ListenerPort: 10000})
+			r.Construct(ctx)
+
+			runErr := data.(scenario)(ctx, r)
+
+			snapshot, err := r.Snapshot()
+			if err != nil {
+				return nil, err
+			}
+
+			res := result{
+				version:      snapshot.GetVersion(resource.ClusterType),
+				virtualHosts: make(map[string]*route.VirtualHost),
+				endpoints:    make(map[string][]string),
+			}
+
+			rc := snapshot.GetResources(resource.RouteType)[envoy.RouteName].(*route.RouteConfiguration)
+			for _, vh := range rc.VirtualHosts {
+				res.virtualHosts[vh.Name] = vh
+			}
+
+			for name, v := range snapshot.GetResources(resource.EndpointType) {
+				for _, lb := range v.(*endpoint.ClusterLoadAssignment).Endpoints[0].LbEndpoints {
+					res.endpoints[name] = append(res.endpoints[name], lb.GetEndpoint().Address.GetSocketAddress().Address)
+				}
+			}
+
+			return res, runErr
+		},
+		assertion: map[string]TestAssertion{
+			"register_replicas": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *envoy.RegistryXDS) error {
+						return registerAll(ctx, r, whoami1, whoami2)
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"example.com"}, res.virtualHosts["whoami"].Domains)
+					assert.Equal(t, []string{"172.18.0.2", "172.18.0.3"}, res.endpoints["whoami"])
+				},
+			},
+			"domain_default_to_service": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *envoy.RegistryXDS) error {
+						return r.Register(ctx, newContainer("whoami-1", "172.18.0.2", nil))
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami"}, res.virtualHosts["whoami"].Domains)
+					assert.Equal(t, "/", res.virtualHosts["whoami"].Routes[0].Match.GetPrefix())
+				},
+			},
+			"duplicate_domain_rejected": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *envoy.RegistryXDS) error {
+						other := dockertest.Container{
+							Name:    "other-1",
+							Service: "other",
+							IP:      "172.18.0.4",
+							Labels:  map[string]string{"turu.envoy.domains": "api.example.com, example.com"},
+						}.Build()
+						return registerAll(ctx, r, whoami1, other)
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "domain example.com is already used by service whoami")
+					assert.Len(t, res.virtualHosts, 1)
+					assert.NotContains(t, res.endpoints, "other")
+					assert.Equal(t, "2", res.version)
+				},
+			},
+			"invalid_snapshot_restore_service": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *envoy.RegistryXDS) error {
+						// virtual host without domain is rejected by envoy
+						invalid := newContainer("whoami-2", "172.18.0.3", map[string]string{"turu.envoy.domains": ","})
+						return registerAll(ctx, r, whoami1, invalid)
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "invalid envoy resource turu_route")
+					assert.Equal(t, []string{"example.com"}, res.virtualHosts["whoami"].Domains)
+					assert.Equal(t, []string{"172.18.0.2"}, res.endpoints["whoami"])
+				},
+			},
+			"deregister": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *envoy.RegistryXDS) error {
+						if err := registerAll(ctx, r, whoami1, whoami2); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, whoami1)
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"172.18.0.3"}, res.endpoints["whoami"])
+				},
+			},
+			"deregister_last_remove_service": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *envoy.RegistryXDS) error {
+						if err := r.Register(ctx, whoami1); err != nil {
+							return err
+						}
+						// the second deregister is already deregistered, no new version is pushed
+						for range 2 {
+							if err := r.Deregister(ctx, whoami1); err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Empty(t, res.virtualHosts)
+					assert.Empty(t, res.endpoints)
+					assert.Equal(t, "3", res.version)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
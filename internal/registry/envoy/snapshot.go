@@ -0,0 +1,221 @@
+package envoy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	router "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	ListenerName = "turu_http"
+	RouteName    = "turu_route"
+)
+
+// Service is served to envoy as cluster with its endpoints and virtual host
+type Service struct {
+	Name    string
+	Domains []string
+	Prefix  string
+
+	// endpoints keyed by container id
+	Endpoints map[string][]Endpoint
+}
+
+type Endpoint struct {
+	Address string
+	Port    uint32
+}
+
+// CreateSnapshot build clusters, endpoints, routes and http listener of services
+func CreateSnapshot(version string, services map[string]*Service, listenerPort uint32) (*cache.Snapshot, error) {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var (
+		clusters     []types.Resource
+		endpoints    []types.Resource
+		virtualHosts []*route.VirtualHost
+	)
+
+	for _, k := range names {
+		s := services[k]
+		clusters = append(clusters, createCluster(s))
+		endpoints = append(endpoints, createLoadAssignment(s))
+		virtualHosts = append(virtualHosts, createVirtualHost(s))
+	}
+
+	l, err := createListener(listenerPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.NewSnapshot(version, map[resource.Type][]types.Resource{
+		resource.ClusterType:  clusters,
+		resource.EndpointType: endpoints,
+		resource.RouteType: {
+			&route.RouteConfiguration{
+				Name:         RouteName,
+				VirtualHosts: virtualHosts,
+			},
+		},
+		resource.ListenerType: {l},
+	})
+}
+
+// ValidateSnapshot check every resource against envoy constraints so invalid
+// configuration, e.g. virtual host without domain, is not served to envoy
+func ValidateSnapshot(snapshot *cache.Snapshot) error {
+	for _, t := range []resource.Type{resource.ClusterType, resource.EndpointType, resource.RouteType, resource.ListenerType} {
+		for name, r := range snapshot.GetResources(t) {
+			v, ok := r.(interface{ Validate() error })
+			if !ok {
+				continue
+			}
+			if err := v.Validate(); err != nil {
+				return fmt.Errorf("invalid envoy resource %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func adsConfigSource() *core.ConfigSource {
+	return &core.ConfigSource{
+		ResourceApiVersion: core.ApiVersion_V3,
+		ConfigSourceSpecifier: &core.ConfigSource_Ads{
+			Ads: &core.AggregatedConfigSource{},
+		},
+	}
+}
+
+func socketAddress(address string, port uint32) *core.Address {
+	return &core.Address{
+		Address: &core.Address_SocketAddress{
+			SocketAddress: &core.SocketAddress{
+				Protocol: core.SocketAddress_TCP,
+				Address:  address,
+				PortSpecifier: &core.SocketAddress_PortValue{
+					PortValue: port,
+				},
+			},
+		},
+	}
+}
+
+func createCluster(s *Service) *cluster.Cluster {
+	return &cluster.Cluster{
+		Name:                 s.Name,
+		ConnectTimeout:       durationpb.New(5 * time.Second),
+		ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_EDS},
+		LbPolicy:             cluster.Cluster_ROUND_ROBIN,
+		EdsClusterConfig: &cluster.Cluster_EdsClusterConfig{
+			EdsConfig: adsConfigSource(),
+		},
+	}
+}
+
+func createLoadAssignment(s *Service) *endpoint.ClusterLoadAssignment {
+	ids := make([]string, 0, len(s.Endpoints))
+	for k := range s.Endpoints {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+
+	var lbs []*endpoint.LbEndpoint
+	for _, id := range ids {
+		for _, e := range s.Endpoints[id] {
+			lbs = append(lbs, &endpoint.LbEndpoint{
+				HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+					Endpoint: &endpoint.Endpoint{
+						Address: socketAddress(e.Address, e.Port),
+					},
+				},
+			})
+		}
+	}
+
+	return &endpoint.ClusterLoadAssignment{
+		ClusterName: s.Name,
+		Endpoints: []*endpoint.LocalityLbEndpoints{
+			{LbEndpoints: lbs},
+		},
+	}
+}
+
+func createVirtualHost(s *Service) *route.VirtualHost {
+	return &route.VirtualHost{
+		Name:    s.Name,
+		Domains: s.Domains,
+		Routes: []*route.Route{
+			{
+				Match: &route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{Prefix: s.Prefix},
+				},
+				Action: &route.Route_Route{
+					Route: &route.RouteAction{
+						ClusterSpecifier: &route.RouteAction_Cluster{Cluster: s.Name},
+					},
+				},
+			},
+		},
+	}
+}
+
+func createListener(port uint32) (*listener.Listener, error) {
+	routerConfig, err := anypb.New(&router.Router{})
+	if err != nil {
+		return nil, err
+	}
+
+	manager, err := anypb.New(&hcm.HttpConnectionManager{
+		CodecType:  hcm.HttpConnectionManager_AUTO,
+		StatPrefix: ListenerName,
+		RouteSpecifier: &hcm.HttpConnectionManager_Rds{
+			Rds: &hcm.Rds{
+				ConfigSource:    adsConfigSource(),
+				RouteConfigName: RouteName,
+			},
+		},
+		HttpFilters: []*hcm.HttpFilter{
+			{
+				Name:       "envoy.filters.http.router",
+				ConfigType: &hcm.HttpFilter_TypedConfig{TypedConfig: routerConfig},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &listener.Listener{
+		Name:    ListenerName,
+		Address: socketAddress("0.0.0.0", port),
+		FilterChains: []*listener.FilterChain{
+			{
+				Filters: []*listener.Filter{
+					{
+						Name:       "envoy.filters.network.http_connection_manager",
+						ConfigType: &listener.Filter_TypedConfig{TypedConfig: manager},
+					},
+				},
+			},
+		},
+	}, nil
+}
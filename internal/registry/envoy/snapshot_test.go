@@ -0,0 +1,54 @@
+package envoy_test
+
+import (
+	"testing"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/praswicaksono/turu/internal/registry/envoy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateSnapshot(t *testing.T) {
+	services := map[string]*envoy.Service{
+		"whoami": {
+			Name:    "whoami",
+			Domains: []string{"example.com"},
+			Prefix:  "/",
+			Endpoints: map[string][]envoy.Endpoint{
+				"container-1": {{Address: "172.18.0.2", Port: 80}},
+				"container-2": {{Address: "172.18.0.3", Port: 80}},
+			},
+		},
+	}
+
+	snapshot, err := envoy.CreateSnapshot("1", services, 10000)
+
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Consistent())
+	assert.Equal(t, "1", snapshot.GetVersion(resource.ClusterType))
+
+	clusters := snapshot.GetResources(resource.ClusterType)
+	assert.Equal(t, 1, len(clusters))
+	assert.Equal(t, cluster.Cluster_EDS, clusters["whoami"].(*cluster.Cluster).GetType())
+
+	cla := snapshot.GetResources(resource.EndpointType)["whoami"].(*endpoint.ClusterLoadAssignment)
+	assert.Equal(t, 2, len(cla.Endpoints[0].LbEndpoints))
+	assert.Equal(t, "172.18.0.2", cla.Endpoints[0].LbEndpoints[0].GetEndpoint().Address.GetSocketAddress().Address)
+
+	rc := snapshot.GetResources(resource.RouteType)[envoy.RouteName].(*route.RouteConfiguration)
+	assert.Equal(t, []string{"example.com"}, rc.VirtualHosts[0].Domains)
+	assert.Equal(t, "whoami", rc.VirtualHosts[0].Routes[0].GetRoute().GetCluster())
+
+	assert.Equal(t, 1, len(snapshot.GetResources(resource.ListenerType)))
+}
+
+func TestCreateEmptySnapshot(t *testing.T) {
+	snapshot, err := envoy.CreateSnapshot("1", map[string]*envoy.Service{}, 10000)
+
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Consistent())
+	assert.Empty(t, snapshot.GetResources(resource.ClusterType))
+}
@@ -0,0 +1,9 @@
+package etcd
+
+import "github.com/praswicaksono/turu/internal/conf"
+
+// NewRegistryEtcdWithClient create registry using the given client instead of
+// connecting to cfg endpoints
+func NewRegistryEtcdWithClient(cfg *conf.Etcd, ec Client) *RegistryEtcd {
+	return &RegistryEtcd{cfg: cfg, ec: ec}
+}
@@ -0,0 +1,226 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/etcdutil"
+	"github.com/rs/zerolog/log"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	defaultPrefix = "/turu/services"
+	defaultTTL    = 30 * time.Second
+	maxBackoff    = 30 * time.Second
+)
+
+// Node is json value stored for every registered container
+type Node struct {
+	ContainerID string   `json:"container_id"`
+	Container   string   `json:"container"`
+	Service     string   `json:"service"`
+	Nodes       []string `json:"nodes"`
+}
+
+// Client is etcd operations used by the registry
+type Client interface {
+	// Grant create lease of ttl and keep it alive, returned channel is closed
+	// once the lease could not be kept alive
+	Grant(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, <-chan *clientv3.LeaseKeepAliveResponse, error)
+	Put(ctx context.Context, key string, value string, lease clientv3.LeaseID) error
+	Delete(ctx context.Context, key string) error
+}
+
+// client is Client backed by etcd client
+type client struct {
+	ec *clientv3.Client
+}
+
+func (c client) Grant(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, <-chan *clientv3.LeaseKeepAliveResponse, error) {
+	res, err := c.ec.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return clientv3.NoLease, nil, err
+	}
+
+	ch, err := c.ec.KeepAlive(ctx, res.ID)
+	if err != nil {
+		return clientv3.NoLease, nil, err
+	}
+
+	return res.ID, ch, nil
+}
+
+func (c client) Put(ctx context.Context, key string, value string, lease clientv3.LeaseID) error {
+	_, err := c.ec.Put(ctx, key, value, clientv3.WithLease(lease))
+	return err
+}
+
+func (c client) Delete(ctx context.Context, key string) error {
+	_, err := c.ec.Delete(ctx, key)
+	return err
+}
+
+// RegistryEtcd write plain service membership as <prefix>/<service>/<container-id>
+// key attached to lease kept alive by turu, so keys expire when turu dies
+type RegistryEtcd struct {
+	cfg    *conf.Etcd
+	ec     Client
+	prefix string
+	ttl    time.Duration
+
+	m     *sync.Mutex
+	lease clientv3.LeaseID
+	keys  map[string]string
+}
+
+func NewRegistryEtcd(cfg *conf.Etcd) *RegistryEtcd {
+	return &RegistryEtcd{cfg: cfg}
+}
+
+func (p *RegistryEtcd) Construct(ctx context.Context) {
+	if p.m != nil {
+		return
+	}
+
+	cfg := p.cfg
+	if cfg == nil || (len(cfg.Endpoint) == 0 && p.ec == nil) {
+		log.Fatal().Msg("etcd.endpoint could not be empty")
+	}
+
+	if p.ec == nil {
+		cli, err := etcdutil.NewClient(cfg.EtcdConnection)
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		p.ec = client{ec: cli}
+	}
+
+	p.prefix = strings.TrimSuffix(cfg.Prefix, "/")
+	if p.prefix == "" {
+		p.prefix = defaultPrefix
+	}
+	p.ttl = cfg.TTL
+	if p.ttl == 0 {
+		p.ttl = defaultTTL
+	}
+
+	p.m = &sync.Mutex{}
+	p.keys = make(map[string]string)
+
+	go p.keepAlive(ctx)
+}
+
+// Key return etcd key of container
+func (p *RegistryEtcd) Key(service string, id string) string {
+	return fmt.Sprintf("%s/%s/%s", p.prefix, service, id)
+}
+
+// keepAlive keep the lease alive, when the lease is lost e.g. etcd was
+// unreachable longer than ttl, new lease is granted and every key put back
+func (p *RegistryEtcd) keepAlive(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		ch, err := p.grant(ctx)
+		if err == nil {
+			backoff = time.Second
+			// channel is closed once the lease could not be kept alive
+			for range ch {
+			}
+			err = errors.New("lease keep alive stopped")
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Error().Err(err).Dur("retry_in", backoff).Msg("etcd lease lost")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// grant create new lease and put registered keys with it
+func (p *RegistryEtcd) grant(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	lease, ch, err := p.ec.Grant(ctx, p.ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.lease = lease
+	for k, v := range p.keys {
+		err = p.ec.Put(ctx, k, v, p.lease)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info().Int64("lease", int64(p.lease)).Int("keys", len(p.keys)).Msg("etcd lease granted")
+
+	return ch, nil
+}
+
+func (p *RegistryEtcd) Register(ctx context.Context, c types.ContainerJSON) error {
+	name, service := docker.GetContainerOrServiceName(c)
+
+	node := Node{
+		ContainerID: c.ID,
+		Container:   name,
+		Service:     service,
+		Nodes:       []string{},
+	}
+	for _, v := range docker.GetLoadBalancerURL(name, c) {
+		if v != "" {
+			node.Nodes = append(node.Nodes, v)
+		}
+	}
+
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	key := p.Key(service, c.ID)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// key is put once lease granted
+	p.keys[key] = string(b)
+	if p.lease == clientv3.NoLease {
+		return nil
+	}
+
+	return p.ec.Put(ctx, key, string(b), p.lease)
+}
+
+func (p *RegistryEtcd) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	_, service := docker.GetContainerOrServiceName(c)
+	key := p.Key(service, c.ID)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	delete(p.keys, key)
+
+	// deleting missing key is not an error
+	return p.ec.Delete(ctx, key)
+}
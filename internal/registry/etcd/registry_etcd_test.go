@@ -0,0 +1,236 @@
+package etcd_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/etcd"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// value is key stored in fake etcd with the lease it is attached to
+type value struct {
+	data  string
+	lease clientv3.LeaseID
+}
+
+// fakeClient keep keys in memory, lease live until expire is called
+type fakeClient struct {
+	m      sync.Mutex
+	lease  clientv3.LeaseID
+	alive  map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse
+	values map[string]value
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		alive:  make(map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse),
+		values: make(map[string]value),
+	}
+}
+
+func (f *fakeClient) Grant(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, <-chan *clientv3.LeaseKeepAliveResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.lease++
+	ch := make(chan *clientv3.LeaseKeepAliveResponse)
+	f.alive[f.lease] = ch
+
+	return f.lease, ch, nil
+}
+
+func (f *fakeClient) Put(ctx context.Context, key string, data string, lease clientv3.LeaseID) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.values[key] = value{data: data, lease: lease}
+
+	return nil
+}
+
+func (f *fakeClient) Delete(ctx context.Context, key string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	delete(f.values, key)
+
+	return nil
+}
+
+// expire stop keeping the lease alive and remove keys attached to it, like
+// etcd does once ttl passed without keep alive
+func (f *fakeClient) expire(lease clientv3.LeaseID) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	close(f.alive[lease])
+	delete(f.alive, lease)
+	for k, v := range f.values {
+		if v.lease == lease {
+			delete(f.values, k)
+		}
+	}
+}
+
+// granted wait until lease is granted
+func (f *fakeClient) granted(t *testing.T, lease clientv3.LeaseID) bool {
+	return assert.Eventually(t, func() bool {
+		f.m.Lock()
+		defer f.m.Unlock()
+		return f.lease >= lease
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// snapshot return copy of stored keys
+func (f *fakeClient) snapshot() map[string]value {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	values := make(map[string]value, len(f.values))
+	for k, v := range f.values {
+		values[k] = v
+	}
+
+	return values
+}
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is registry calls of single test case, run once the first lease
+// is granted
+type scenario = func(ctx context.Context, r *etcd.RegistryEtcd, f *fakeClient) error
+
+const key = "/turu/services/whoami/whoami-1"
+
+func TestRegistryEtcd(t *testing.T) {
+	// register wait until the key is stored, registry may still be putting
+	// keys of the first lease
+	register := func(ctx context.Context, r *etcd.RegistryEtcd, f *fakeClient) error {
+		if err := r.Register(ctx, dockertest.Container{}.Build()); err != nil {
+			return err
+		}
+		assert.Eventually(t, func() bool {
+			_, ok := f.snapshot()[key]
+			return ok
+		}, 5*time.Second, 10*time.Millisecond)
+		return nil
+	}
+
+	table := TestTable{
+		test: func(data any) (any, error) {
+			f := newFakeClient()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			r := etcd.NewRegistryEtcdWithClient(&conf.Etcd{}, f)
+			r.Construct(ctx)
+			f.granted(t, 1)
+
+			err := data.(scenario)(ctx, r, f)
+			return f.snapshot(), err
+		},
+		assertion: map[string]TestAssertion{
+			"register_attach_lease": {
+				data: func() any {
+					return scenario(register)
+				},
+				expectation: func(obj any, err error) {
+					values := obj.(map[string]value)
+					assert.NoError(t, err)
+					assert.Equal(t, clientv3.LeaseID(1), values[key].lease)
+
+					var node etcd.Node
+					assert.NoError(t, json.Unmarshal([]byte(values[key].data), &node))
+					assert.Equal(t, etcd.Node{
+						ContainerID: "whoami-1",
+						Container:   "whoami-1",
+						Service:     "whoami",
+						Nodes:       []string{"whoami-1:80"},
+					}, node)
+				},
+			},
+			"deregister_twice": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *etcd.RegistryEtcd, f *fakeClient) error {
+						if err := register(ctx, r, f); err != nil {
+							return err
+						}
+						if err := r.Deregister(ctx, dockertest.Container{}.Build()); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, dockertest.Container{}.Build())
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj)
+				},
+			},
+			"lease_lost_put_again": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *etcd.RegistryEtcd, f *fakeClient) error {
+						if err := register(ctx, r, f); err != nil {
+							return err
+						}
+						f.expire(1)
+						f.granted(t, 2)
+
+						// keys are put with the new lease right after it is
+						// granted, wait until it is done
+						assert.Eventually(t, func() bool {
+							return f.snapshot()[key].lease == 2
+						}, 5*time.Second, 10*time.Millisecond)
+						return nil
+					})
+				},
+				expectation: func(obj any, err error) {
+					values := obj.(map[string]value)
+					assert.NoError(t, err)
+					assert.Len(t, values, 1)
+					assert.Equal(t, clientv3.LeaseID(2), values[key].lease)
+				},
+			},
+			"lease_lost_deregistered_not_put": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *etcd.RegistryEtcd, f *fakeClient) error {
+						if err := register(ctx, r, f); err != nil {
+							return err
+						}
+						if err := r.Deregister(ctx, dockertest.Container{}.Build()); err != nil {
+							return err
+						}
+						f.expire(1)
+						f.granted(t, 2)
+						return nil
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
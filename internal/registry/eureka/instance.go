@@ -0,0 +1,92 @@
+package eureka
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+// Instance is eureka instance info, only fields required by eureka server and
+// spring cloud client are defined
+type Instance struct {
+	InstanceID       string            `json:"instanceId"`
+	HostName         string            `json:"hostName"`
+	App              string            `json:"app"`
+	IPAddr           string            `json:"ipAddr"`
+	VipAddress       string            `json:"vipAddress"`
+	SecureVipAddress string            `json:"secureVipAddress"`
+	Status           string            `json:"status"`
+	Port             Port              `json:"port"`
+	SecurePort       Port              `json:"securePort"`
+	DataCenterInfo   DataCenterInfo    `json:"dataCenterInfo"`
+	LeaseInfo        LeaseInfo         `json:"leaseInfo"`
+	Metadata         map[string]string `json:"metadata"`
+}
+
+type Port struct {
+	Port    int    `json:"$"`
+	Enabled string `json:"@enabled"`
+}
+
+type DataCenterInfo struct {
+	Class string `json:"@class"`
+	Name  string `json:"name"`
+}
+
+type LeaseInfo struct {
+	RenewalIntervalInSecs int `json:"renewalIntervalInSecs"`
+	DurationInSecs        int `json:"durationInSecs"`
+}
+
+// CreateInstances create instance for every exposed port of container, lease
+// duration is three heartbeat intervals like eureka default
+func CreateInstances(cnt types.ContainerJSON, interval time.Duration) []Instance {
+	name, service := docker.GetContainerOrServiceName(cnt)
+	renewal := max(int(interval.Seconds()), 1)
+
+	var instances []Instance
+	for port := range cnt.Config.ExposedPorts {
+		instances = append(instances, Instance{
+			InstanceID:       fmt.Sprintf("%s:%s", name, port.Port()),
+			HostName:         name,
+			App:              appName(service),
+			IPAddr:           instanceAddress(name, cnt),
+			VipAddress:       service,
+			SecureVipAddress: service,
+			Status:           "UP",
+			Port:             Port{Port: port.Int(), Enabled: "true"},
+			SecurePort:       Port{Port: 443, Enabled: "false"},
+			DataCenterInfo: DataCenterInfo{
+				Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo",
+				Name:  "MyOwn",
+			},
+			LeaseInfo: LeaseInfo{
+				RenewalIntervalInSecs: renewal,
+				DurationInSecs:        renewal * 3,
+			},
+			Metadata: map[string]string{
+				"containerId": cnt.ID,
+			},
+		})
+	}
+
+	return instances
+}
+
+// appName return eureka application name, eureka store it in upper case
+func appName(service string) string {
+	return strings.ToUpper(service)
+}
+
+// instanceAddress return container ip, eureka client connect to ipAddr when
+// preferIpAddress is set and hostName otherwise
+func instanceAddress(name string, c types.ContainerJSON) string {
+	if ipv4, _ := docker.GetContainerIPs(c); len(ipv4) > 0 {
+		return ipv4[0]
+	}
+
+	return name
+}
@@ -0,0 +1,142 @@
+package eureka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/heartbeat"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultTimeout           = 5 * time.Second
+)
+
+// RegistryEureka register instance through eureka rest api and renew its
+// lease on every heartbeat, instance not renewed is evicted by eureka
+type RegistryEureka struct {
+	cfg *conf.Eureka
+	c   *http.Client
+	hb  *heartbeat.Heartbeat
+}
+
+func NewRegistryEureka(cfg *conf.Eureka) *RegistryEureka {
+	return &RegistryEureka{cfg: cfg}
+}
+
+func (p *RegistryEureka) Construct(ctx context.Context) {
+	if p.c != nil {
+		return
+	}
+
+	cfg := p.cfg
+	if cfg == nil || cfg.URL == "" {
+		log.Fatal().Msg("eureka.url could not be empty")
+	}
+
+	interval := cfg.HeartbeatInterval
+	if interval == 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	p.c = &http.Client{Timeout: defaultTimeout}
+	if cfg.Timeout > 0 {
+		p.c.Timeout = cfg.Timeout
+	}
+	p.hb = heartbeat.New("eureka", interval)
+
+	p.hb.Start(ctx)
+}
+
+// Heartbeat renew every registered instance once
+func (p *RegistryEureka) Heartbeat(ctx context.Context) error {
+	return p.hb.Beat(ctx)
+}
+
+// request call eureka rest api, it returns response status code
+func (p *RegistryEureka) request(ctx context.Context, method string, path string, body any) (int, error) {
+	var r io.Reader
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(j)
+	}
+
+	url := strings.TrimSuffix(p.cfg.URL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotFound {
+		return res.StatusCode, fmt.Errorf("eureka %s %s: %d", method, path, res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+func (p *RegistryEureka) register(ctx context.Context, i Instance) error {
+	_, err := p.request(ctx, http.MethodPost, "/apps/"+i.App, map[string]Instance{"instance": i})
+	return err
+}
+
+func (p *RegistryEureka) Register(ctx context.Context, c types.ContainerJSON) error {
+	for _, i := range CreateInstances(c, p.hb.Interval()) {
+		err := p.register(ctx, i)
+		if err != nil {
+			return err
+		}
+
+		path := fmt.Sprintf("/apps/%s/%s", i.App, i.InstanceID)
+		p.hb.Add(path, func(ctx context.Context) error {
+			status, err := p.request(ctx, http.MethodPut, path, nil)
+			if err != nil {
+				return err
+			}
+
+			// eureka forgot the instance e.g. after restart
+			if status == http.StatusNotFound {
+				log.Ctx(ctx).Warn().Str("instance", i.InstanceID).Msg("eureka instance not found, registering again")
+				return p.register(ctx, i)
+			}
+
+			return nil
+		})
+	}
+
+	return nil
+}
+
+func (p *RegistryEureka) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	for _, i := range CreateInstances(c, p.hb.Interval()) {
+		path := fmt.Sprintf("/apps/%s/%s", i.App, i.InstanceID)
+		p.hb.Remove(path)
+
+		// cancelling unknown instance is not an error
+		_, err := p.request(ctx, http.MethodDelete, path, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package eureka_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEureka implement subset of eureka rest api used by turu
+type fakeEureka struct {
+	m         sync.Mutex
+	instances map[string]eureka.Instance
+	renewals  int
+}
+
+func (f *fakeEureka) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/eureka/apps/"), "/")
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1:
+		var body map[string]eureka.Instance
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.instances[parts[0]+"/"+body["instance"].InstanceID] = body["instance"]
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && len(parts) == 2:
+		if _, ok := f.instances[parts[0]+"/"+parts[1]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.renewals++
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		if _, ok := f.instances[parts[0]+"/"+parts[1]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.instances, parts[0]+"/"+parts[1])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+func newContainer() types.ContainerJSON {
+	return dockertest.Container{IP: "172.18.0.2"}.Build()
+}
+
+// scenario is registry calls of single test case, fake is given to simulate
+// eureka forgetting instances
+type scenario = func(ctx context.Context, r *eureka.RegistryEureka, fake *fakeEureka) error
+
+func TestRegistryEureka(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			fake := &fakeEureka{instances: map[string]eureka.Instance{}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			ctx := context.Background()
+			r := eureka.NewRegistryEureka(&conf.Eureka{URL: srv.URL + "/eureka/", HeartbeatInterval: time.Hour})
+			r.Construct(ctx)
+
+			if err := r.Register(ctx, newContainer()); err != nil {
+				return nil, err
+			}
+
+			return fake, data.(scenario)(ctx, r, fake)
+		},
+		assertion: map[string]TestAssertion{
+			"register": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *eureka.RegistryEureka, fake *fakeEureka) error {
+						return nil
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+
+					i, ok := obj.(*fakeEureka).instances["WHOAMI/whoami-1:80"]
+					assert.True(t, ok)
+					assert.Equal(t, "whoami-1", i.HostName)
+					assert.Equal(t, "172.18.0.2", i.IPAddr)
+					assert.Equal(t, "whoami", i.VipAddress)
+					assert.Equal(t, 80, i.Port.Port)
+					assert.Equal(t, "UP", i.Status)
+					assert.Equal(t, eureka.LeaseInfo{RenewalIntervalInSecs: 3600, DurationInSecs: 10800}, i.LeaseInfo)
+				},
+			},
+			"heartbeat_renew": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *eureka.RegistryEureka, fake *fakeEureka) error {
+						return r.Heartbeat(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, 1, obj.(*fakeEureka).renewals)
+				},
+			},
+			"heartbeat_register_forgotten": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *eureka.RegistryEureka, fake *fakeEureka) error {
+						delete(fake.instances, "WHOAMI/whoami-1:80")
+						return r.Heartbeat(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Contains(t, obj.(*fakeEureka).instances, "WHOAMI/whoami-1:80")
+				},
+			},
+			"deregister_twice_not_renewed": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *eureka.RegistryEureka, fake *fakeEureka) error {
+						if err := r.Deregister(ctx, newContainer()); err != nil {
+							return err
+						}
+						if err := r.Deregister(ctx, newContainer()); err != nil {
+							return err
+						}
+						return r.Heartbeat(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					fake := obj.(*fakeEureka)
+					assert.NoError(t, err)
+					assert.Empty(t, fake.instances)
+					assert.Equal(t, 0, fake.renewals)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
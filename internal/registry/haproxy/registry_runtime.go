@@ -0,0 +1,276 @@
+package haproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/command"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/fileutil"
+	"github.com/rs/zerolog/log"
+)
+
+var errNoSuchBackend = errors.New("no such backend")
+
+// RegistryRuntime add and remove servers of backend named after the service
+// through haproxy runtime api. When the backend does not exist yet, it is
+// written into configuration fragment and haproxy reloaded.
+type RegistryRuntime struct {
+	cfg *conf.Haproxy
+	m   *sync.Mutex
+}
+
+func NewRegistryRuntime(cfg *conf.Haproxy) *RegistryRuntime {
+	return &RegistryRuntime{cfg: cfg}
+}
+
+func (p *RegistryRuntime) Construct(ctx context.Context) {
+	if p.m == nil {
+		p.m = &sync.Mutex{}
+	}
+}
+
+func (p *RegistryRuntime) config() (*conf.Haproxy, error) {
+	cfg := p.cfg
+	if cfg == nil || cfg.Socket == "" {
+		return nil, errors.New("haproxy.socket could not be empty")
+	}
+
+	return cfg, nil
+}
+
+// execute send command to runtime api and return its response. Commands
+// answer nothing on success unless success responses are given, any other
+// response is error. Missing server is not error, caller check the response.
+func (p *RegistryRuntime) execute(ctx context.Context, cmd string, success ...string) (string, error) {
+	cfg, err := p.config()
+	if err != nil {
+		return "", err
+	}
+
+	network, address := "unix", strings.TrimPrefix(cfg.Socket, "unix://")
+	if strings.HasPrefix(cfg.Socket, "tcp://") {
+		network, address = "tcp", strings.TrimPrefix(cfg.Socket, "tcp://")
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = conn.Write([]byte(cmd + "\n"))
+	if err != nil {
+		return "", err
+	}
+
+	// non interactive mode close connection after the response
+	res, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	out := strings.TrimSpace(string(res))
+	log.Ctx(ctx).Debug().Str("command", cmd).Str("response", out).Msg("haproxy runtime api")
+
+	switch {
+	case out == "":
+		return out, nil
+	case strings.HasPrefix(out, "No such backend"):
+		return out, errNoSuchBackend
+	case strings.HasPrefix(out, "No such server"):
+		return out, nil
+	}
+
+	for _, v := range success {
+		if strings.HasPrefix(out, v) {
+			return out, nil
+		}
+	}
+
+	return out, fmt.Errorf("haproxy %s: %s", cmd, out)
+}
+
+func (p *RegistryRuntime) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	_, service := docker.GetContainerOrServiceName(c)
+	if err := docker.ValidateServiceName(service); err != nil {
+		return err
+	}
+
+	servers := CreateServers(c)
+
+	for _, s := range servers {
+		srv := service + "/" + s.Name
+
+		out, err := p.execute(ctx, fmt.Sprintf("add server %s %s", srv, s.Address), "New server registered", "Already exists")
+		if errors.Is(err, errNoSuchBackend) {
+			return p.writeFragment(ctx, service, servers, nil, true)
+		}
+		if err != nil {
+			return err
+		}
+
+		// server left by previous registration
+		if strings.HasPrefix(out, "Already exists") {
+			_, err = p.execute(ctx, fmt.Sprintf("set server %s addr %s", srv, strings.Replace(s.Address, ":", " port ", 1)), "IP changed", "no need to change")
+			if err != nil {
+				return err
+			}
+		}
+
+		// dynamic server start in maintenance mode
+		_, err = p.execute(ctx, fmt.Sprintf("set server %s state ready", srv))
+		if err != nil {
+			return err
+		}
+	}
+
+	// keep fragment in sync so servers survive reload
+	return p.writeFragment(ctx, service, servers, nil, false)
+}
+
+func (p *RegistryRuntime) Drain(ctx context.Context, c types.ContainerJSON) (bool, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	_, service := docker.GetContainerOrServiceName(c)
+	if err := docker.ValidateServiceName(service); err != nil {
+		return false, err
+	}
+
+	drained := false
+	for _, s := range CreateServers(c) {
+		out, err := p.execute(ctx, fmt.Sprintf("set server %s/%s state drain", service, s.Name))
+		if errors.Is(err, errNoSuchBackend) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !strings.HasPrefix(out, "No such server") {
+			drained = true
+		}
+	}
+
+	return drained, nil
+}
+
+func (p *RegistryRuntime) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	_, service := docker.GetContainerOrServiceName(c)
+	if err := docker.ValidateServiceName(service); err != nil {
+		return err
+	}
+
+	servers := CreateServers(c)
+
+	for _, s := range servers {
+		srv := service + "/" + s.Name
+
+		// server must be in maintenance without session before deleted
+		for _, cmd := range []string{"set server %s state maint", "shutdown sessions server %s", "del server %s"} {
+			out, err := p.execute(ctx, fmt.Sprintf(cmd, srv), "Server deleted")
+			if errors.Is(err, errNoSuchBackend) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			if strings.HasPrefix(out, "No such server") {
+				break
+			}
+		}
+	}
+
+	return p.writeFragment(ctx, service, nil, servers, false)
+}
+
+// writeFragment add and remove servers of service configuration fragment. The
+// fragment is created only when create is true, that is when backend does not
+// exist yet, in this case haproxy is validated and reloaded.
+func (p *RegistryRuntime) writeFragment(ctx context.Context, service string, add []Server, remove []Server, create bool) error {
+	cfg := p.cfg
+	if cfg.ConfigDir == "" {
+		if create {
+			return fmt.Errorf("backend %s not found and haproxy.config-dir is empty", service)
+		}
+		return nil
+	}
+
+	path := filepath.Join(cfg.ConfigDir, service+".cfg")
+
+	prev, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return nil
+		}
+		prev = nil
+	} else if err != nil {
+		return err
+	}
+
+	servers := ParseServers(prev)
+	for _, s := range add {
+		servers = slices.DeleteFunc(servers, func(x Server) bool { return x.Name == s.Name })
+		servers = append(servers, s)
+	}
+	servers = slices.DeleteFunc(servers, func(x Server) bool {
+		return slices.ContainsFunc(remove, func(s Server) bool { return s.Name == x.Name })
+	})
+
+	b, err := (&Backend{Name: service, Servers: servers}).Render()
+	if err != nil {
+		return err
+	}
+
+	if slices.Equal(b, prev) {
+		return nil
+	}
+
+	err = fileutil.WriteFileAtomic(path, b, 0644)
+	if err != nil {
+		return err
+	}
+
+	// existing backend is updated through runtime api, no reload needed
+	if !create {
+		return nil
+	}
+
+	err = command.Run(ctx, cfg.CheckCommand)
+	if err != nil {
+		if rerr := os.Remove(path); rerr != nil {
+			log.Ctx(ctx).Error().Err(rerr).Str("path", path).Msg("failed to remove invalid haproxy configuration")
+		}
+		return err
+	}
+
+	log.Ctx(ctx).Info().Str("backend", service).Msg("backend not found, haproxy reloaded with new backend")
+
+	return command.Run(ctx, cfg.ReloadCommand)
+}
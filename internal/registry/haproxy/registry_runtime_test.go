@@ -0,0 +1,361 @@
+package haproxy_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/haproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRuntime implement subset of haproxy runtime api used by turu, it answers
+// one command per connection like haproxy non interactive mode
+type fakeRuntime struct {
+	m        sync.Mutex
+	backends map[string]map[string]string
+	commands []string
+	// failures is response of command starting with the key instead of
+	// executing it
+	failures map[string]string
+}
+
+func (f *fakeRuntime) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte(f.handle(strings.TrimSpace(line)) + "\n"))
+		conn.Close()
+	}
+}
+
+func (f *fakeRuntime) handle(cmd string) string {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.commands = append(f.commands, cmd)
+
+	for k, v := range f.failures {
+		if strings.HasPrefix(cmd, k) {
+			return v
+		}
+	}
+
+	fields := strings.Fields(cmd)
+	var target string
+	switch {
+	case strings.HasPrefix(cmd, "shutdown sessions server"):
+		target = fields[3]
+	case len(fields) >= 3:
+		target = fields[2]
+	default:
+		return "Unknown command."
+	}
+
+	backend, server, _ := strings.Cut(target, "/")
+	servers, ok := f.backends[backend]
+	if !ok {
+		return "No such backend."
+	}
+
+	if strings.HasPrefix(cmd, "add server") {
+		if _, ok := servers[server]; ok {
+			return "Already exists a server with the same name in backend."
+		}
+		servers[server] = "maint"
+		return "New server registered."
+	}
+
+	if _, ok := servers[server]; !ok {
+		return "No such server."
+	}
+
+	switch {
+	case strings.HasPrefix(cmd, "set server") && fields[3] == "state":
+		servers[server] = fields[4]
+	case strings.HasPrefix(cmd, "set server") && fields[3] == "addr":
+		return fmt.Sprintf("IP changed from '%s' to '%s' by 'stats socket command'", fields[4], fields[4])
+	case strings.HasPrefix(cmd, "del server"):
+		delete(servers, server)
+		return "Server deleted."
+	}
+
+	return ""
+}
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is backends known by haproxy at runtime and registry calls of
+// single test case
+type scenario struct {
+	backends map[string]map[string]string
+	failures map[string]string
+	run      func(ctx context.Context, r *haproxy.RegistryRuntime) error
+}
+
+// result is haproxy runtime state and config dir after scenario run
+type result struct {
+	f   *fakeRuntime
+	dir string
+}
+
+func newContainer() types.ContainerJSON {
+	return dockertest.Container{IP: "172.18.0.2"}.Build()
+}
+
+func register(ctx context.Context, r *haproxy.RegistryRuntime) error {
+	return r.Register(ctx, newContainer())
+}
+
+func TestRegistryRuntime(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			sc := data.(scenario)
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			defer l.Close()
+
+			f := &fakeRuntime{backends: sc.backends, failures: sc.failures}
+			go f.serve(l)
+
+			dir := t.TempDir()
+			r := haproxy.NewRegistryRuntime(&conf.Haproxy{
+				Socket:    "tcp://" + l.Addr().String(),
+				ConfigDir: dir,
+			})
+
+			ctx := context.Background()
+			r.Construct(ctx)
+
+			err = sc.run(ctx, r)
+			return result{f: f, dir: dir}, err
+		},
+		assertion: map[string]TestAssertion{
+			"register_runtime": {
+				data: func() any {
+					return scenario{backends: map[string]map[string]string{"whoami": {}}, run: register}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, "ready", res.f.backends["whoami"]["whoami-1-80"])
+					assert.Contains(t, res.f.commands, "add server whoami/whoami-1-80 172.18.0.2:80")
+
+					// backend exist at runtime, fragment is not created
+					_, err = os.Stat(filepath.Join(res.dir, "whoami.cfg"))
+					assert.ErrorIs(t, err, os.ErrNotExist)
+				},
+			},
+			"drain": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{"whoami": {}},
+						run: func(ctx context.Context, r *haproxy.RegistryRuntime) error {
+							if err := register(ctx, r); err != nil {
+								return err
+							}
+							drained, err := r.Drain(ctx, newContainer())
+							assert.True(t, drained)
+							return err
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, "drain", obj.(result).f.backends["whoami"]["whoami-1-80"])
+				},
+			},
+			"deregister_runtime": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{"whoami": {}},
+						run: func(ctx context.Context, r *haproxy.RegistryRuntime) error {
+							if err := register(ctx, r); err != nil {
+								return err
+							}
+							return r.Deregister(ctx, newContainer())
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj.(result).f.backends["whoami"])
+				},
+			},
+			"deregister_unknown": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{"whoami": {}},
+						run: func(ctx context.Context, r *haproxy.RegistryRuntime) error {
+							if err := r.Deregister(ctx, newContainer()); err != nil {
+								return err
+							}
+							drained, err := r.Drain(ctx, newContainer())
+							assert.False(t, drained)
+							return err
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+				},
+			},
+			"register_again": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{"whoami": {"whoami-1-80": "maint"}},
+						run:      register,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, "ready", res.f.backends["whoami"]["whoami-1-80"])
+					assert.Contains(t, res.f.commands, "set server whoami/whoami-1-80 addr 172.18.0.2 port 80")
+				},
+			},
+			"register_rejected": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{"whoami": {}},
+						failures: map[string]string{"add server": "Backend must use a dynamic load balancing to support dynamic servers."},
+						run:      register,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "Backend must use a dynamic load balancing")
+					assert.Empty(t, res.f.backends["whoami"])
+				},
+			},
+			"deregister_rejected": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{"whoami": {"whoami-1-80": "ready"}},
+						failures: map[string]string{"del server": "Server still has connections attached to it, cannot remove it."},
+						run: func(ctx context.Context, r *haproxy.RegistryRuntime) error {
+							return r.Deregister(ctx, newContainer())
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "cannot remove it")
+					assert.Equal(t, "maint", res.f.backends["whoami"]["whoami-1-80"])
+				},
+			},
+			"register_compose_replicas": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{"shop-web": {}},
+						run: func(ctx context.Context, r *haproxy.RegistryRuntime) error {
+							for i, ip := range []string{"172.18.0.2", "172.18.0.3"} {
+								cnt := dockertest.Container{
+									Name: fmt.Sprintf("shop-web-%d", i+1),
+									IP:   ip,
+									Labels: map[string]string{
+										"com.docker.compose.project": "shop",
+										"com.docker.compose.service": "web",
+									},
+								}.Build()
+								if err := r.Register(ctx, cnt); err != nil {
+									return err
+								}
+							}
+							return nil
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					// replicas share service name but not server name
+					assert.Equal(t, map[string]string{"shop-web-1-80": "ready", "shop-web-2-80": "ready"}, res.f.backends["shop-web"])
+					assert.Contains(t, res.f.commands, "add server shop-web/shop-web-2-80 172.18.0.3:80")
+				},
+			},
+			"invalid_service": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{},
+						run: func(ctx context.Context, r *haproxy.RegistryRuntime) error {
+							cnt := dockertest.Container{Service: "../whoami", IP: "172.18.0.2"}.Build()
+							return r.Register(ctx, cnt)
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "invalid service name")
+					assert.Empty(t, res.f.commands)
+				},
+			},
+			"register_fragment": {
+				data: func() any {
+					return scenario{backends: map[string]map[string]string{}, run: register}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+
+					b, err := os.ReadFile(filepath.Join(obj.(result).dir, "whoami.cfg"))
+					assert.NoError(t, err)
+					assert.Equal(t, `# managed by turu, do not edit
+backend whoami
+    balance roundrobin
+    server whoami-1-80 172.18.0.2:80 check
+`, string(b))
+				},
+			},
+			"deregister_fragment": {
+				data: func() any {
+					return scenario{
+						backends: map[string]map[string]string{},
+						run: func(ctx context.Context, r *haproxy.RegistryRuntime) error {
+							if err := register(ctx, r); err != nil {
+								return err
+							}
+							return r.Deregister(ctx, newContainer())
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+
+					b, err := os.ReadFile(filepath.Join(obj.(result).dir, "whoami.cfg"))
+					assert.NoError(t, err)
+					assert.Empty(t, haproxy.ParseServers(b))
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package haproxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+// Server is haproxy server of container exposed port
+type Server struct {
+	Name    string
+	Address string
+}
+
+// Backend hold data rendered into fallback configuration fragment
+type Backend struct {
+	Name    string
+	Servers []Server
+}
+
+var backendTemplate = template.Must(template.New("backend").Parse(`# managed by turu, do not edit
+backend {{ .Name }}
+    balance roundrobin
+{{- range .Servers }}
+    server {{ .Name }} {{ .Address }} check
+{{- end }}
+`))
+
+// CreateServers create server for every exposed port of container, container
+// ip is used as address since dynamic server could not resolve hostname. Server
+// is named after the container, not the service, since compose replicas share
+// their service name.
+func CreateServers(cnt types.ContainerJSON) []Server {
+	name, _ := docker.GetContainerOrServiceName(cnt)
+
+	host := name
+	if ipv4, _ := docker.GetContainerIPs(cnt); len(ipv4) > 0 {
+		host = ipv4[0]
+	}
+
+	var servers []Server
+	for port := range cnt.Config.ExposedPorts {
+		servers = append(servers, Server{
+			Name:    fmt.Sprintf("%s-%s", strings.TrimPrefix(cnt.Name, "/"), port.Port()),
+			Address: fmt.Sprintf("%s:%s", host, port.Port()),
+		})
+	}
+
+	return servers
+}
+
+func (b *Backend) Render() ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := backendTemplate.Execute(&buf, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseServers read servers from configuration fragment rendered by turu
+func ParseServers(b []byte) []Server {
+	var servers []Server
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) >= 3 && f[0] == "server" {
+			servers = append(servers, Server{Name: f[1], Address: f[2]})
+		}
+	}
+
+	return servers
+}
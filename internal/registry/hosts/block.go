@@ -0,0 +1,109 @@
+package hosts
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+)
+
+const (
+	BeginMarker = "# BEGIN turu managed block, do not edit"
+	EndMarker   = "# END turu managed block"
+)
+
+// Entry is single line of managed block, Container identify the entry owner
+type Entry struct {
+	IP        string
+	Service   string
+	Container string
+}
+
+func (e Entry) String() string {
+	return e.IP + " " + e.Service + " " + e.Container
+}
+
+// File is hosts file split around turu managed block, lines outside the block
+// are kept byte to byte
+type File struct {
+	before  []byte
+	after   []byte
+	Entries []Entry
+}
+
+// Parse split hosts file content, content without managed block get the
+// block appended at the end
+func Parse(b []byte) (*File, error) {
+	f := &File{}
+
+	begin := bytes.Index(b, []byte(BeginMarker))
+	if begin < 0 {
+		f.before = b
+		if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+			f.before = append(slices.Clone(b), '\n')
+		}
+		return f, nil
+	}
+
+	end := bytes.Index(b[begin:], []byte(EndMarker))
+	if end < 0 {
+		return nil, errors.New("hosts file has begin marker without end marker")
+	}
+	end += begin
+
+	f.before = b[:begin]
+	f.after = bytes.TrimPrefix(b[end+len(EndMarker):], []byte("\n"))
+
+	for _, line := range strings.Split(string(b[begin+len(BeginMarker):end]), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		f.Entries = append(f.Entries, Entry{IP: fields[0], Service: fields[1], Container: fields[2]})
+	}
+
+	return f, nil
+}
+
+// Set replace entries of container, it returns false when nothing changed
+func (f *File) Set(container string, entries []Entry) bool {
+	var curr []Entry
+	for _, e := range f.Entries {
+		if e.Container == container {
+			curr = append(curr, e)
+		}
+	}
+
+	if slices.Equal(curr, entries) {
+		return false
+	}
+
+	f.Remove(container)
+	f.Entries = append(f.Entries, entries...)
+
+	return true
+}
+
+// Remove remove entries of container, it returns false when nothing changed
+func (f *File) Remove(container string) bool {
+	n := len(f.Entries)
+	f.Entries = slices.DeleteFunc(f.Entries, func(e Entry) bool {
+		return e.Container == container
+	})
+
+	return len(f.Entries) != n
+}
+
+func (f *File) Bytes() []byte {
+	var buf bytes.Buffer
+
+	buf.Write(f.before)
+	buf.WriteString(BeginMarker + "\n")
+	for _, e := range f.Entries {
+		buf.WriteString(e.String() + "\n")
+	}
+	buf.WriteString(EndMarker + "\n")
+	buf.Write(f.after)
+
+	return buf.Bytes()
+}
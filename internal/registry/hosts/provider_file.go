@@ -0,0 +1,100 @@
+package hosts
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+// RegistryFile maintain turu managed block of hosts format file, every
+// container ip is written as "<ip> <service> <container>" line
+type RegistryFile struct {
+	cfg *conf.HostsFile
+	m   *sync.Mutex
+}
+
+func NewRegistryFile(cfg *conf.HostsFile) *RegistryFile {
+	return &RegistryFile{cfg: cfg}
+}
+
+func (p *RegistryFile) Construct(ctx context.Context) {
+	if p.m == nil {
+		p.m = &sync.Mutex{}
+	}
+}
+
+func (p *RegistryFile) path() (string, error) {
+	if p.cfg == nil || p.cfg.Path == "" {
+		return "", errors.New("hosts-file.path could not be empty")
+	}
+
+	return p.cfg.Path, nil
+}
+
+// update apply fn to hosts file and write it back when fn report change
+func (p *RegistryFile) update(fn func(f *File) bool) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path, err := p.path()
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, err := Parse(b)
+	if err != nil {
+		return err
+	}
+
+	if !fn(f) {
+		return nil
+	}
+
+	// hosts file is commonly bind mounted, writing in place keep the mount
+	// working where rename would not
+	return os.WriteFile(path, f.Bytes(), 0644)
+}
+
+// containerName return name of container which entries are keyed by, compose
+// replicas share their service name so the container name is used instead
+func containerName(c types.ContainerJSON) string {
+	return strings.TrimPrefix(c.Name, "/")
+}
+
+func (p *RegistryFile) Register(ctx context.Context, c types.ContainerJSON) error {
+	_, service := docker.GetContainerOrServiceName(c)
+	name := containerName(c)
+
+	ipv4, ipv6 := docker.GetContainerIPs(c)
+	if len(ipv4) == 0 && len(ipv6) == 0 {
+		return errors.New("container has no ip address, could not register to hosts file")
+	}
+
+	var entries []Entry
+	for _, ip := range append(ipv4, ipv6...) {
+		entries = append(entries, Entry{IP: ip, Service: service, Container: name})
+	}
+
+	return p.update(func(f *File) bool {
+		return f.Set(name, entries)
+	})
+}
+
+func (p *RegistryFile) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	name := containerName(c)
+
+	return p.update(func(f *File) bool {
+		return f.Remove(name)
+	})
+}
@@ -0,0 +1,176 @@
+package hosts_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/hosts"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is registry calls of single test case
+type scenario = func(ctx context.Context, r *hosts.RegistryFile) error
+
+func newContainer(name string, ip string) types.ContainerJSON {
+	return dockertest.Container{Name: name, IP: ip}.Build()
+}
+
+func register(ctx context.Context, r *hosts.RegistryFile) error {
+	for _, c := range []types.ContainerJSON{
+		newContainer("whoami-1", "172.18.0.2"),
+		newContainer("whoami-2", "172.18.0.3"),
+		newContainer("whoami-1", "172.18.0.2"),
+	} {
+		if err := r.Register(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestParseKeepUnmanagedLines(t *testing.T) {
+	content := `127.0.0.1 localhost
+# BEGIN turu managed block, do not edit
+172.18.0.9 old old-1
+# END turu managed block
+10.0.0.1 db
+`
+
+	f, err := hosts.Parse([]byte(content))
+	assert.NoError(t, err)
+	assert.Equal(t, []hosts.Entry{{IP: "172.18.0.9", Service: "old", Container: "old-1"}}, f.Entries)
+
+	assert.True(t, f.Remove("old-1"))
+	assert.False(t, f.Remove("old-1"))
+	assert.Equal(t, `127.0.0.1 localhost
+# BEGIN turu managed block, do not edit
+# END turu managed block
+10.0.0.1 db
+`, string(f.Bytes()))
+
+	_, err = hosts.Parse([]byte("# BEGIN turu managed block, do not edit\n"))
+	assert.Error(t, err)
+}
+
+func TestRegistryFile(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			path := filepath.Join(t.TempDir(), "hosts")
+			if err := os.WriteFile(path, []byte("127.0.0.1 localhost"), 0644); err != nil {
+				return nil, err
+			}
+
+			ctx := context.Background()
+			r := hosts.NewRegistryFile(&conf.HostsFile{Path: path})
+			r.Construct(ctx)
+
+			if err := data.(scenario)(ctx, r); err != nil {
+				return nil, err
+			}
+
+			b, err := os.ReadFile(path)
+			return string(b), err
+		},
+		assertion: map[string]TestAssertion{
+			"register_twice_not_duplicated": {
+				data: func() any {
+					return scenario(register)
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, `127.0.0.1 localhost
+# BEGIN turu managed block, do not edit
+172.18.0.2 whoami whoami-1
+172.18.0.3 whoami whoami-2
+# END turu managed block
+`, obj)
+				},
+			},
+			"compose_replicas": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *hosts.RegistryFile) error {
+						for i, ip := range []string{"172.18.0.2", "172.18.0.3"} {
+							cnt := dockertest.Container{
+								Name: fmt.Sprintf("shop-web-%d", i+1),
+								IP:   ip,
+								Labels: map[string]string{
+									"com.docker.compose.project": "shop",
+									"com.docker.compose.service": "web",
+								},
+							}.Build()
+							if err := r.Register(ctx, cnt); err != nil {
+								return err
+							}
+						}
+
+						// replica which died has no ip anymore
+						return r.Deregister(ctx, dockertest.Container{
+							Name: "shop-web-1",
+							Labels: map[string]string{
+								"com.docker.compose.project": "shop",
+								"com.docker.compose.service": "web",
+							},
+						}.Build())
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, `127.0.0.1 localhost
+# BEGIN turu managed block, do not edit
+172.18.0.3 shop-web shop-web-2
+# END turu managed block
+`, obj)
+				},
+			},
+			"deregister_twice": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *hosts.RegistryFile) error {
+						if err := register(ctx, r); err != nil {
+							return err
+						}
+						for _, c := range []types.ContainerJSON{
+							newContainer("whoami-1", "172.18.0.2"),
+							newContainer("whoami-2", "172.18.0.3"),
+							newContainer("whoami-2", "172.18.0.3"),
+						} {
+							if err := r.Deregister(ctx, c); err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, `127.0.0.1 localhost
+# BEGIN turu managed block, do not edit
+# END turu managed block
+`, obj)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
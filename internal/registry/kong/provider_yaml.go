@@ -0,0 +1,401 @@
+package kong
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/goccy/go-yaml"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/fileutil"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	formatVersion  = "3.0"
+	defaultTimeout = 5 * time.Second
+)
+
+// RegistryYaml maintain services, routes and upstream targets in kong
+// declarative configuration file. When admin url is set, the file is posted
+// to kong /config endpoint after every change for DB-less kong.
+type RegistryYaml struct {
+	cfg *conf.KongYaml
+	m   *sync.Mutex
+	c   *http.Client
+}
+
+func NewRegistryYaml(cfg *conf.KongYaml) *RegistryYaml {
+	return &RegistryYaml{cfg: cfg}
+}
+
+func (p *RegistryYaml) Construct(ctx context.Context) {
+	if p.m == nil {
+		p.m = &sync.Mutex{}
+		p.c = &http.Client{Timeout: defaultTimeout}
+		if cfg := p.cfg; cfg != nil && cfg.Timeout > 0 {
+			p.c.Timeout = cfg.Timeout
+		}
+	}
+}
+
+func (p *RegistryYaml) path() (string, error) {
+	if p.cfg == nil || p.cfg.Path == "" {
+		return "", errors.New("kong-yaml.path could not be empty")
+	}
+
+	return p.cfg.Path, nil
+}
+
+// readConfig read declarative configuration, missing file treated as empty
+// configuration
+func (p *RegistryYaml) readConfig(path string) (Config, error) {
+	cfg := Config{}
+
+	f, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err == nil {
+		err = yaml.Unmarshal(f, &cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			cfg = Config{}
+		}
+	}
+
+	if _, ok := cfg["_format_version"]; !ok {
+		cfg["_format_version"] = formatVersion
+	}
+
+	return cfg, nil
+}
+
+func (p *RegistryYaml) writeConfig(ctx context.Context, path string, cfg Config) error {
+	s, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = fileutil.WriteFileAtomic(path, s, 0644)
+	if err != nil {
+		return err
+	}
+
+	return p.push(ctx, cfg)
+}
+
+// entities return list under key, nil is returned when it is missing
+func entities(m map[string]any, key string) ([]any, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+
+	l, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a list", key)
+	}
+
+	return l, nil
+}
+
+// setEntities put list under key, empty list is removed
+func setEntities(m map[string]any, key string, l []any) {
+	if len(l) == 0 {
+		delete(m, key)
+		return
+	}
+
+	m[key] = l
+}
+
+// find return index and entity named name, -1 is returned when not found
+func find(l []any, name string) (int, map[string]any, error) {
+	for i, v := range l {
+		e, ok := v.(map[string]any)
+		if !ok {
+			return -1, nil, fmt.Errorf("entity %d is not a table", i)
+		}
+		if e["name"] == name {
+			return i, e, nil
+		}
+	}
+
+	return -1, nil, nil
+}
+
+// isManaged check entity is tagged managed-by-turu
+func isManaged(e map[string]any) bool {
+	tags, _ := e["tags"].([]any)
+	return slices.Contains(tags, any(TAG_MANAGED_BY_TURU))
+}
+
+// merge replace fields turu own of current entity with generated one, other
+// fields such as route strip_path set by hand are kept
+func merge(curr map[string]any, entry map[string]any, owned []string) {
+	for _, k := range owned {
+		delete(curr, k)
+	}
+	maps.Copy(curr, entry)
+}
+
+// encode convert entity to generic table, yaml keep integer as integer
+func encode(v any) (map[string]any, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	return m, yaml.Unmarshal(b, &m)
+}
+
+// push load configuration into DB-less kong, nothing is done when admin url
+// is not set
+func (p *RegistryYaml) push(ctx context.Context, cfg Config) error {
+	adminURL := p.cfg.AdminURL
+	if adminURL == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(adminURL, "/") + "/config"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("kong admin POST /config: %d %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	log.Ctx(ctx).Debug().Str("url", url).Msg("kong configuration loaded")
+
+	return nil
+}
+
+func (p *RegistryYaml) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path, err := p.path()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := p.readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	svc, upstream, err := CreateService(c)
+	if err != nil {
+		return err
+	}
+
+	services, err := entities(cfg, "services")
+	if err != nil {
+		return err
+	}
+	upstreams, err := entities(cfg, "upstreams")
+	if err != nil {
+		return err
+	}
+
+	i, currSvc, err := find(services, svc.Name)
+	if err != nil {
+		return fmt.Errorf("services: %w", err)
+	}
+	if i >= 0 && !isManaged(currSvc) {
+		return fmt.Errorf("kong service %s is not tagged %s, refusing to replace it", svc.Name, TAG_MANAGED_BY_TURU)
+	}
+
+	j, currUpstream, err := find(upstreams, upstream.Name)
+	if err != nil {
+		return fmt.Errorf("upstreams: %w", err)
+	}
+	if j >= 0 && !isManaged(currUpstream) {
+		return fmt.Errorf("kong upstream %s is not tagged %s, refusing to replace it", upstream.Name, TAG_MANAGED_BY_TURU)
+	}
+
+	entry, err := encode(svc)
+	if err != nil {
+		return err
+	}
+
+	// service and route follow the labels of the latest container
+	if i >= 0 {
+		err = mergeRoutes(currSvc, entry)
+		if err != nil {
+			return err
+		}
+		merge(currSvc, entry, serviceFields)
+	} else {
+		services = append(services, entry)
+	}
+
+	// merge targets if upstream exist
+	if j >= 0 {
+		targets, err := entities(currUpstream, "targets")
+		if err != nil {
+			return err
+		}
+		for _, t := range upstream.Targets {
+			if !slices.ContainsFunc(targets, func(v any) bool { return hasTarget(v, t.Target) }) {
+				target, err := encode(t)
+				if err != nil {
+					return err
+				}
+				targets = append(targets, target)
+			}
+		}
+		currUpstream["targets"] = targets
+	} else {
+		entry, err := encode(upstream)
+		if err != nil {
+			return err
+		}
+		upstreams = append(upstreams, entry)
+	}
+
+	setEntities(cfg, "services", services)
+	setEntities(cfg, "upstreams", upstreams)
+
+	return p.writeConfig(ctx, path, cfg)
+}
+
+var (
+	// serviceFields and routeFields are fields turu set on service and route
+	serviceFields = []string{"name", "host", "port", "protocol", "routes", "plugins", "tags"}
+	routeFields   = []string{"name", "paths", "hosts"}
+)
+
+// mergeRoutes keep fields set by hand on routes of current service in routes
+// of generated service
+func mergeRoutes(curr map[string]any, entry map[string]any) error {
+	routes, err := entities(curr, "routes")
+	if err != nil {
+		return err
+	}
+
+	generated, err := entities(entry, "routes")
+	if err != nil {
+		return err
+	}
+
+	for i, v := range generated {
+		r, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		_, old, err := find(routes, fmt.Sprint(r["name"]))
+		if err != nil {
+			return fmt.Errorf("routes: %w", err)
+		}
+		if old != nil {
+			merge(old, r, routeFields)
+			generated[i] = old
+		}
+	}
+
+	return nil
+}
+
+func hasTarget(v any, target string) bool {
+	t, ok := v.(map[string]any)
+	return ok && t["target"] == target
+}
+
+func (p *RegistryYaml) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path, err := p.path()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := p.readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	name, service := docker.GetContainerOrServiceName(c)
+
+	upstreams, err := entities(cfg, "upstreams")
+	if err != nil {
+		return err
+	}
+
+	i, curr, err := find(upstreams, service)
+	if err != nil {
+		return fmt.Errorf("upstreams: %w", err)
+	}
+	if i < 0 || !isManaged(curr) {
+		return nil
+	}
+
+	targets, err := entities(curr, "targets")
+	if err != nil {
+		return err
+	}
+
+	removed := CreateTargets(name, c)
+	left := slices.DeleteFunc(slices.Clone(targets), func(v any) bool {
+		return slices.ContainsFunc(removed, func(t *Target) bool { return hasTarget(v, t.Target) })
+	})
+
+	if len(left) == len(targets) {
+		return nil
+	}
+
+	// if there is no target left, delete the upstream and service
+	if len(left) == 0 {
+		setEntities(cfg, "upstreams", slices.Delete(upstreams, i, i+1))
+
+		services, err := entities(cfg, "services")
+		if err != nil {
+			return err
+		}
+		j, svc, err := find(services, service)
+		if err != nil {
+			return fmt.Errorf("services: %w", err)
+		}
+		if j >= 0 && isManaged(svc) {
+			setEntities(cfg, "services", slices.Delete(services, j, j+1))
+		}
+	} else {
+		curr["targets"] = left
+	}
+
+	return p.writeConfig(ctx, path, cfg)
+}
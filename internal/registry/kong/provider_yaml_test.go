@@ -0,0 +1,329 @@
+package kong_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/goccy/go-yaml"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/kong"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+func newContainer(name string) types.ContainerJSON {
+	return dockertest.Container{
+		Name: name,
+		Labels: map[string]string{
+			"turu.kong.paths":                 "/api, /v1",
+			"turu.kong.hosts":                 "example.com",
+			"turu.kong.plugins.rate-limiting": `{"minute": 5}`,
+			"turu.kong.plugins.cors":          "",
+		},
+	}.Build()
+}
+
+// service is result of kong.CreateService
+type service struct {
+	svc      *kong.Service
+	upstream *kong.Upstream
+}
+
+func TestCreateService(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			svc, upstream, err := kong.CreateService(data.(types.ContainerJSON))
+			return service{svc: svc, upstream: upstream}, err
+		},
+		assertion: map[string]TestAssertion{
+			"labels": {
+				data: func() any {
+					return newContainer("whoami-1")
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(service)
+					assert.NoError(t, err)
+					assert.Equal(t, "whoami", res.svc.Name)
+					assert.Equal(t, "whoami", res.svc.Host)
+					assert.Equal(t, []*kong.Route{{Name: "whoami", Paths: []string{"/api", "/v1"}, Hosts: []string{"example.com"}}}, res.svc.Routes)
+					assert.Equal(t, []*kong.Plugin{
+						{Name: "cors"},
+						{Name: "rate-limiting", Config: map[string]any{"minute": float64(5)}},
+					}, res.svc.Plugins)
+					assert.Equal(t, []*kong.Target{{Target: "whoami-1:80", Weight: 100}}, res.upstream.Targets)
+				},
+			},
+			"invalid_plugin_config": {
+				data: func() any {
+					cnt := newContainer("whoami-1")
+					cnt.Config.Labels["turu.kong.plugins.cors"] = "{invalid"
+					return cnt
+				},
+				expectation: func(obj any, err error) {
+					assert.ErrorContains(t, err, "turu.kong.plugins.cors")
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
+
+// declarative is typed view of kong declarative configuration
+type declarative struct {
+	FormatVersion string           `json:"_format_version"`
+	Services      []*kong.Service  `json:"services"`
+	Upstreams     []*kong.Upstream `json:"upstreams"`
+	Consumers     []map[string]any `json:"consumers"`
+}
+
+// fakeKong record configuration posted to /config
+type fakeKong struct {
+	m      sync.Mutex
+	config declarative
+	posts  int
+}
+
+func (f *fakeKong) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if r.Method != http.MethodPost || r.URL.Path != "/config" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.posts++
+	if err := json.NewDecoder(r.Body).Decode(&f.config); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+const baseConfig = `_format_version: "3.0"
+consumers:
+  - username: alice
+certificates:
+  - cert: cert.pem
+    key: key.pem
+routes:
+  - name: health
+    paths: [/health]
+`
+
+// scenario is initial declarative config and registry calls of single test
+// case
+type scenario struct {
+	content string
+	run     func(ctx context.Context, r *kong.RegistryYaml) error
+}
+
+// result is declarative config file and config posted to kong
+type result struct {
+	cfg   declarative
+	raw   kong.Config
+	admin *fakeKong
+}
+
+func register(ctx context.Context, r *kong.RegistryYaml) error {
+	if err := r.Register(ctx, newContainer("whoami-1")); err != nil {
+		return err
+	}
+	return r.Register(ctx, newContainer("whoami-2"))
+}
+
+func deregister(ctx context.Context, r *kong.RegistryYaml) error {
+	for _, name := range []string{"whoami-1", "whoami-2", "whoami-2"} {
+		if err := r.Deregister(ctx, newContainer(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entity return entity named name under key of raw config
+func entity(cfg kong.Config, key string, name string) map[string]any {
+	l, _ := cfg[key].([]any)
+	for _, v := range l {
+		if e, ok := v.(map[string]any); ok && e["name"] == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func TestRegistryYaml(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			c := data.(scenario)
+			admin := &fakeKong{}
+			srv := httptest.NewServer(admin)
+			defer srv.Close()
+
+			path := filepath.Join(t.TempDir(), "kong.yaml")
+			if err := os.WriteFile(path, []byte(baseConfig+c.content), 0644); err != nil {
+				return nil, err
+			}
+
+			ctx := context.Background()
+			r := kong.NewRegistryYaml(&conf.KongYaml{Path: path, AdminURL: srv.URL})
+			r.Construct(ctx)
+
+			runErr := c.run(ctx, r)
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			res := result{admin: admin}
+			if err := yaml.Unmarshal(b, &res.raw); err != nil {
+				return nil, err
+			}
+			return res, errors.Join(runErr, yaml.Unmarshal(b, &res.cfg))
+		},
+		assertion: map[string]TestAssertion{
+			"register": {
+				data: func() any {
+					return scenario{run: register}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, "3.0", res.cfg.FormatVersion)
+					assert.Len(t, res.cfg.Services, 1)
+					assert.Len(t, res.cfg.Upstreams, 1)
+					assert.Equal(t, []*kong.Target{{Target: "whoami-1:80", Weight: 100}, {Target: "whoami-2:80", Weight: 100}}, res.cfg.Upstreams[0].Targets)
+					// unmanaged entities are kept
+					assert.Equal(t, []map[string]any{{"username": "alice"}}, res.cfg.Consumers)
+					assert.Equal(t, []any{map[string]any{"cert": "cert.pem", "key": "key.pem"}}, res.raw["certificates"])
+					assert.NotNil(t, entity(res.raw, "routes", "health"))
+
+					assert.Equal(t, 2, res.admin.posts)
+					assert.Equal(t, res.cfg.Upstreams[0].Targets, res.admin.config.Upstreams[0].Targets)
+				},
+			},
+			"keep_fields_of_managed_service": {
+				data: func() any {
+					return scenario{
+						content: `services:
+  - name: whoami
+    host: whoami
+    read_timeout: 30000
+    tags: [managed-by-turu]
+    routes:
+      - name: whoami
+        paths: [/old]
+        strip_path: false
+        methods: [GET]
+upstreams:
+  - name: whoami
+    algorithm: least-connections
+    tags: [managed-by-turu]
+    targets:
+      - target: whoami-1:80
+        weight: 50
+`,
+						run: register,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+
+					svc := entity(res.raw, "services", "whoami")
+					assert.Equal(t, uint64(30000), svc["read_timeout"])
+					route := svc["routes"].([]any)[0].(map[string]any)
+					assert.Equal(t, []any{"/api", "/v1"}, route["paths"])
+					assert.Equal(t, false, route["strip_path"])
+					assert.Equal(t, []any{"GET"}, route["methods"])
+
+					upstream := entity(res.raw, "upstreams", "whoami")
+					assert.Equal(t, "least-connections", upstream["algorithm"])
+					assert.Equal(t, []*kong.Target{{Target: "whoami-1:80", Weight: 50}, {Target: "whoami-2:80", Weight: 100}}, res.cfg.Upstreams[0].Targets)
+				},
+			},
+			"refuse_unmanaged_service": {
+				data: func() any {
+					return scenario{
+						content: `services:
+  - name: whoami
+    host: whoami.internal
+`,
+						run: register,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "kong service whoami is not tagged managed-by-turu")
+					assert.Equal(t, []*kong.Service{{Name: "whoami", Host: "whoami.internal"}}, res.cfg.Services)
+					assert.Empty(t, res.cfg.Upstreams)
+					assert.Equal(t, 0, res.admin.posts)
+				},
+			},
+			"deregister_keep_unmanaged_upstream": {
+				data: func() any {
+					return scenario{
+						content: `upstreams:
+  - name: whoami
+    targets:
+      - target: whoami-1:80
+`,
+						run: deregister,
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, []*kong.Target{{Target: "whoami-1:80"}}, res.cfg.Upstreams[0].Targets)
+					assert.Equal(t, 0, res.admin.posts)
+				},
+			},
+			"deregister_twice": {
+				data: func() any {
+					return scenario{run: func(ctx context.Context, r *kong.RegistryYaml) error {
+						if err := register(ctx, r); err != nil {
+							return err
+						}
+						return deregister(ctx, r)
+					}}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Empty(t, res.cfg.Services)
+					assert.Empty(t, res.cfg.Upstreams)
+					assert.NotNil(t, entity(res.raw, "routes", "health"))
+					assert.Equal(t, 4, res.admin.posts)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
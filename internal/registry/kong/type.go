@@ -0,0 +1,38 @@
+package kong
+
+// Config is kong declarative configuration read as generic table so sections
+// and fields turu does not know, e.g. certificates or route methods, are kept
+// as is
+type Config = map[string]any
+
+type Service struct {
+	Name     string    `json:"name"`
+	Host     string    `json:"host"`
+	Port     int       `json:"port,omitempty"`
+	Protocol string    `json:"protocol,omitempty"`
+	Routes   []*Route  `json:"routes,omitempty"`
+	Plugins  []*Plugin `json:"plugins,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+type Route struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths,omitempty"`
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+type Plugin struct {
+	Name   string         `json:"name"`
+	Config map[string]any `json:"config,omitempty"`
+}
+
+type Upstream struct {
+	Name    string    `json:"name"`
+	Targets []*Target `json:"targets,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+}
+
+type Target struct {
+	Target string `json:"target"`
+	Weight int    `json:"weight,omitempty"`
+}
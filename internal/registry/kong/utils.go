@@ -0,0 +1,124 @@
+package kong
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+var (
+	LABEL_TURU_KONG_PATHS   = "turu.kong.paths"
+	LABEL_TURU_KONG_HOSTS   = "turu.kong.hosts"
+	LABEL_TURU_KONG_PLUGINS = "turu.kong.plugins."
+
+	TAG_MANAGED_BY_TURU = "managed-by-turu"
+)
+
+type KongLabel map[string]string
+
+func ExtractLabel(cnt types.ContainerJSON) KongLabel {
+	labels := make(KongLabel)
+
+	for k, v := range cnt.Config.Labels {
+		if strings.HasPrefix(k, "turu.kong.") {
+			labels[k] = v
+		}
+	}
+
+	return labels
+}
+
+// CreateService create service, its route and upstream of container. Service,
+// route and upstream are named after container service, route match every
+// path when neither paths nor hosts label set.
+func CreateService(cnt types.ContainerJSON) (*Service, *Upstream, error) {
+	kongLabels := ExtractLabel(cnt)
+	name, service := docker.GetContainerOrServiceName(cnt)
+
+	r := &Route{
+		Name:  service,
+		Paths: splitLabel(kongLabels[LABEL_TURU_KONG_PATHS]),
+		Hosts: splitLabel(kongLabels[LABEL_TURU_KONG_HOSTS]),
+	}
+	if len(r.Paths) == 0 && len(r.Hosts) == 0 {
+		r.Paths = []string{"/"}
+	}
+
+	plugins, err := createPlugins(kongLabels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &Service{
+		Name:     service,
+		Host:     service,
+		Protocol: "http",
+		Routes:   []*Route{r},
+		Plugins:  plugins,
+		Tags:     []string{TAG_MANAGED_BY_TURU},
+	}
+
+	u := &Upstream{
+		Name:    service,
+		Targets: CreateTargets(name, cnt),
+		Tags:    []string{TAG_MANAGED_BY_TURU},
+	}
+
+	return s, u, nil
+}
+
+// CreateTargets create upstream target for every exposed port of container
+func CreateTargets(name string, cnt types.ContainerJSON) []*Target {
+	var targets []*Target
+	for _, v := range docker.GetLoadBalancerURL(name, cnt) {
+		if v == "" {
+			continue
+		}
+		targets = append(targets, &Target{Target: v, Weight: 100})
+	}
+
+	return targets
+}
+
+// createPlugins create plugin from turu.kong.plugins.<name> labels, label
+// value is plugin config as json object, empty value enable plugin with its
+// default config
+func createPlugins(labels KongLabel) ([]*Plugin, error) {
+	var plugins []*Plugin
+	for k, v := range labels {
+		name, ok := strings.CutPrefix(k, LABEL_TURU_KONG_PLUGINS)
+		if !ok || name == "" {
+			continue
+		}
+
+		p := &Plugin{Name: name}
+		if v = strings.TrimSpace(v); v != "" && v != "true" {
+			err := json.Unmarshal([]byte(v), &p.Config)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s label: %w", k, err)
+			}
+		}
+
+		plugins = append(plugins, p)
+	}
+
+	// labels are map, keep output stable
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+
+	return plugins, nil
+}
+
+func splitLabel(v string) []string {
+	var res []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,257 @@
+package nacos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/heartbeat"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHeartbeatInterval = 5 * time.Second
+	defaultGroup             = "DEFAULT_GROUP"
+	defaultTimeout           = 5 * time.Second
+
+	// beat response code when nacos does not know the instance
+	codeResourceNotFound = 20404
+)
+
+// Instance is ephemeral nacos instance of single exposed port
+type Instance struct {
+	ServiceName string            `json:"serviceName"`
+	IP          string            `json:"ip"`
+	Port        int               `json:"port"`
+	Cluster     string            `json:"cluster"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+type beatResponse struct {
+	Code int `json:"code"`
+}
+
+// RegistryNacos register ephemeral instance through nacos open api and send
+// beat on every heartbeat, instance without beat is removed by nacos
+type RegistryNacos struct {
+	cfg *conf.Nacos
+	c   *http.Client
+	hb  *heartbeat.Heartbeat
+
+	// instances is registered instances by container id, stopped container
+	// lost its ip so it is deregistered from what was registered
+	m         *sync.Mutex
+	instances map[string][]Instance
+}
+
+func NewRegistryNacos(cfg *conf.Nacos) *RegistryNacos {
+	return &RegistryNacos{cfg: cfg}
+}
+
+func (p *RegistryNacos) Construct(ctx context.Context) {
+	if p.c != nil {
+		return
+	}
+
+	cfg := p.cfg
+	if cfg == nil || cfg.Address == "" {
+		log.Fatal().Msg("nacos.address could not be empty")
+	}
+
+	interval := cfg.HeartbeatInterval
+	if interval == 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	p.c = &http.Client{Timeout: defaultTimeout}
+	if cfg.Timeout > 0 {
+		p.c.Timeout = cfg.Timeout
+	}
+	p.hb = heartbeat.New("nacos", interval)
+	p.m = &sync.Mutex{}
+	p.instances = make(map[string][]Instance)
+
+	p.hb.Start(ctx)
+}
+
+// Heartbeat send beat of every registered instance once
+func (p *RegistryNacos) Heartbeat(ctx context.Context) error {
+	return p.hb.Beat(ctx)
+}
+
+// CreateInstances create instance for every exposed port of container
+func CreateInstances(cnt types.ContainerJSON) []Instance {
+	name, service := docker.GetContainerOrServiceName(cnt)
+
+	ip := name
+	if ipv4, _ := docker.GetContainerIPs(cnt); len(ipv4) > 0 {
+		ip = ipv4[0]
+	}
+
+	var instances []Instance
+	for port := range cnt.Config.ExposedPorts {
+		instances = append(instances, Instance{
+			ServiceName: service,
+			IP:          ip,
+			Port:        port.Int(),
+			Cluster:     "DEFAULT",
+			Metadata: map[string]string{
+				"containerId": cnt.ID,
+				"container":   name,
+			},
+		})
+	}
+
+	return instances
+}
+
+// params return query parameters identifying instance
+func (p *RegistryNacos) params(i Instance) url.Values {
+	cfg := p.cfg
+
+	group := cfg.Group
+	if group == "" {
+		group = defaultGroup
+	}
+
+	v := url.Values{}
+	v.Set("serviceName", i.ServiceName)
+	v.Set("groupName", group)
+	v.Set("ip", i.IP)
+	v.Set("port", strconv.Itoa(i.Port))
+	v.Set("clusterName", i.Cluster)
+	v.Set("ephemeral", "true")
+	if cfg.Namespace != "" {
+		v.Set("namespaceId", cfg.Namespace)
+	}
+
+	return v
+}
+
+// request call nacos open api and return response body
+func (p *RegistryNacos) request(ctx context.Context, method string, path string, params url.Values) ([]byte, error) {
+	u := strings.TrimSuffix(p.cfg.Address, "/") + path + "?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := p.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("nacos %s %s: %d %s", method, path, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+func (p *RegistryNacos) register(ctx context.Context, i Instance) error {
+	v := p.params(i)
+
+	metadata, err := json.Marshal(i.Metadata)
+	if err != nil {
+		return err
+	}
+	v.Set("metadata", string(metadata))
+
+	_, err = p.request(ctx, http.MethodPost, "/v1/ns/instance", v)
+
+	return err
+}
+
+func (p *RegistryNacos) beat(ctx context.Context, i Instance) error {
+	b, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	v := p.params(i)
+	v.Set("beat", string(b))
+
+	body, err := p.request(ctx, http.MethodPut, "/v1/ns/instance/beat", v)
+	if err != nil {
+		return err
+	}
+
+	var res beatResponse
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return err
+	}
+
+	// nacos forgot the instance e.g. after restart
+	if res.Code == codeResourceNotFound {
+		log.Ctx(ctx).Warn().Str("service", i.ServiceName).Str("ip", i.IP).Int("port", i.Port).Msg("nacos instance not found, registering again")
+		return p.register(ctx, i)
+	}
+
+	return nil
+}
+
+func instanceKey(i Instance) string {
+	return fmt.Sprintf("%s/%s:%d", i.ServiceName, i.IP, i.Port)
+}
+
+func (p *RegistryNacos) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, i := range CreateInstances(c) {
+		err := p.register(ctx, i)
+		if err != nil {
+			return err
+		}
+
+		p.hb.Add(instanceKey(i), func(ctx context.Context) error {
+			return p.beat(ctx, i)
+		})
+
+		if !slices.ContainsFunc(p.instances[c.ID], func(x Instance) bool { return instanceKey(x) == instanceKey(i) }) {
+			p.instances[c.ID] = append(p.instances[c.ID], i)
+		}
+	}
+
+	return nil
+}
+
+func (p *RegistryNacos) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for len(p.instances[c.ID]) > 0 {
+		i := p.instances[c.ID][0]
+		p.hb.Remove(instanceKey(i))
+
+		// removing unknown instance is not an error
+		_, err := p.request(ctx, http.MethodDelete, "/v1/ns/instance", p.params(i))
+		if err != nil {
+			return err
+		}
+
+		p.instances[c.ID] = p.instances[c.ID][1:]
+	}
+
+	delete(p.instances, c.ID)
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package nacos_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/nacos"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNacos implement subset of nacos open api used by turu, instance is
+// keyed by namespace, group, service, ip and port
+type fakeNacos struct {
+	m         sync.Mutex
+	instances map[string]string
+	beats     int
+}
+
+func (f *fakeNacos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	q := r.URL.Query()
+	key := fmt.Sprintf("%s/%s/%s/%s:%s", q.Get("namespaceId"), q.Get("groupName"), q.Get("serviceName"), q.Get("ip"), q.Get("port"))
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/nacos/v1/ns/instance":
+		f.instances[key] = q.Get("metadata")
+		w.Write([]byte("ok"))
+	case r.Method == http.MethodPut && r.URL.Path == "/nacos/v1/ns/instance/beat":
+		var beat nacos.Instance
+		if err := json.Unmarshal([]byte(q.Get("beat")), &beat); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code := 10200
+		if _, ok := f.instances[key]; !ok {
+			code = 20404
+		}
+		f.beats++
+		json.NewEncoder(w).Encode(map[string]int{"code": code, "clientBeatInterval": 5000})
+	case r.Method == http.MethodDelete && r.URL.Path == "/nacos/v1/ns/instance":
+		delete(f.instances, key)
+		w.Write([]byte("ok"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+func newContainer() types.ContainerJSON {
+	return dockertest.Container{IP: "172.18.0.2"}.Build()
+}
+
+// scenario is registry calls of single test case, fake is given to simulate
+// nacos forgetting instances
+type scenario = func(ctx context.Context, r *nacos.RegistryNacos, fake *fakeNacos) error
+
+const key = "dev/DEFAULT_GROUP/whoami/172.18.0.2:80"
+
+func TestRegistryNacos(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			fake := &fakeNacos{instances: map[string]string{}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			ctx := context.Background()
+			r := nacos.NewRegistryNacos(&conf.Nacos{Address: srv.URL + "/nacos", Namespace: "dev", HeartbeatInterval: time.Hour})
+			r.Construct(ctx)
+
+			if err := r.Register(ctx, newContainer()); err != nil {
+				return nil, err
+			}
+
+			return fake, data.(scenario)(ctx, r, fake)
+		},
+		assertion: map[string]TestAssertion{
+			"register": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *nacos.RegistryNacos, fake *fakeNacos) error {
+						return nil
+					})
+				},
+				expectation: func(obj any, err error) {
+					fake := obj.(*fakeNacos)
+					assert.NoError(t, err)
+					assert.Contains(t, fake.instances, key)
+					assert.JSONEq(t, `{"containerId": "whoami-1", "container": "whoami-1"}`, fake.instances[key])
+				},
+			},
+			"heartbeat_beat": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *nacos.RegistryNacos, fake *fakeNacos) error {
+						return r.Heartbeat(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, 1, obj.(*fakeNacos).beats)
+				},
+			},
+			"heartbeat_register_forgotten": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *nacos.RegistryNacos, fake *fakeNacos) error {
+						delete(fake.instances, key)
+						return r.Heartbeat(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Contains(t, obj.(*fakeNacos).instances, key)
+				},
+			},
+			"deregister_after_die": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *nacos.RegistryNacos, fake *fakeNacos) error {
+						// container which died is inspected without ip
+						return r.Deregister(ctx, dockertest.Container{}.Build())
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj.(*fakeNacos).instances)
+				},
+			},
+			"deregister_twice_no_beat": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *nacos.RegistryNacos, fake *fakeNacos) error {
+						if err := r.Deregister(ctx, newContainer()); err != nil {
+							return err
+						}
+						if err := r.Deregister(ctx, newContainer()); err != nil {
+							return err
+						}
+						return r.Heartbeat(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					fake := obj.(*fakeNacos)
+					assert.NoError(t, err)
+					assert.Empty(t, fake.instances)
+					assert.Equal(t, 0, fake.beats)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
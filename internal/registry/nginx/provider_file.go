@@ -0,0 +1,166 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/command"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/fileutil"
+	"github.com/rs/zerolog/log"
+)
+
+type RegistryFile struct {
+	cfg *conf.Nginx
+	m   *sync.Mutex
+}
+
+func NewRegistryFile(cfg *conf.Nginx) *RegistryFile {
+	return &RegistryFile{cfg: cfg}
+}
+
+func (p *RegistryFile) Construct(ctx context.Context) {
+	if p.m == nil {
+		p.m = &sync.Mutex{}
+	}
+}
+
+func (p *RegistryFile) path(service string) (string, error) {
+	if p.cfg == nil || p.cfg.IncludeDir == "" {
+		return "", errors.New("nginx.include-dir could not be empty")
+	}
+
+	if err := docker.ValidateServiceName(service); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(p.cfg.IncludeDir, service+".conf"), nil
+}
+
+// readServers read servers of service configuration, missing file has no server
+func (p *RegistryFile) readServers(path string) ([]byte, []string, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, ParseServers(b), nil
+}
+
+func (p *RegistryFile) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	name, service := docker.GetContainerOrServiceName(c)
+
+	path, err := p.path(service)
+	if err != nil {
+		return err
+	}
+
+	prev, servers, err := p.readServers(path)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range docker.GetLoadBalancerURL(name, c) {
+		if v != "" && !slices.Contains(servers, v) {
+			servers = append(servers, v)
+		}
+	}
+
+	u, err := CreateUpstream(service, servers, c)
+	if err != nil {
+		return err
+	}
+
+	b, err := u.Render()
+	if err != nil {
+		return err
+	}
+
+	return p.apply(ctx, path, prev, b)
+}
+
+func (p *RegistryFile) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	name, service := docker.GetContainerOrServiceName(c)
+
+	path, err := p.path(service)
+	if err != nil {
+		return err
+	}
+
+	prev, servers, err := p.readServers(path)
+	if err != nil {
+		return err
+	}
+
+	lb := docker.GetLoadBalancerURL(name, c)
+	left := slices.DeleteFunc(slices.Clone(servers), func(s string) bool {
+		return slices.Contains(lb, s)
+	})
+
+	if len(left) == len(servers) {
+		return nil
+	}
+
+	// if there is no server left, remove the service configuration
+	if len(left) == 0 {
+		return p.apply(ctx, path, prev, nil)
+	}
+
+	u, err := CreateUpstream(service, left, c)
+	if err != nil {
+		return err
+	}
+
+	b, err := u.Render()
+	if err != nil {
+		return err
+	}
+
+	return p.apply(ctx, path, prev, b)
+}
+
+// apply write service configuration, or remove it when b is nil, then validate
+// and reload nginx. Previous configuration is restored when validation fails.
+func (p *RegistryFile) apply(ctx context.Context, path string, prev []byte, b []byte) error {
+	err := p.write(path, b)
+	if err != nil {
+		return err
+	}
+
+	err = command.Run(ctx, p.cfg.CheckCommand)
+	if err != nil {
+		if rerr := p.write(path, prev); rerr != nil {
+			log.Ctx(ctx).Error().Err(rerr).Str("path", path).Msg("failed to restore previous nginx configuration")
+		}
+		return err
+	}
+
+	return command.Run(ctx, p.cfg.ReloadCommand)
+}
+
+func (p *RegistryFile) write(path string, b []byte) error {
+	if b == nil {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(path, b, 0644)
+}
@@ -0,0 +1,164 @@
+package nginx_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/nginx"
+	"github.com/stretchr/testify/assert"
+)
+
+// scenario is registry calls of single test case, cfg is given to switch check
+// command between calls
+type scenario = func(ctx context.Context, r *nginx.RegistryFile, cfg *conf.Nginx) error
+
+// result is service configuration and reload count after scenario run, conf
+// is nil when the file does not exist
+type result struct {
+	conf     []byte
+	reloaded int
+}
+
+func newContainer(name string) types.ContainerJSON {
+	return dockertest.Container{Name: name}.Build()
+}
+
+func register(names ...string) scenario {
+	return func(ctx context.Context, r *nginx.RegistryFile, cfg *conf.Nginx) error {
+		for _, name := range names {
+			if err := r.Register(ctx, newContainer(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestRegistryFile(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			dir := t.TempDir()
+			reload := filepath.Join(dir, "reload")
+			if err := os.Mkdir(reload, 0755); err != nil {
+				return nil, err
+			}
+
+			// reload create new file on every call so they could be counted
+			cfg := &conf.Nginx{
+				IncludeDir:    dir,
+				CheckCommand:  "true",
+				ReloadCommand: "mktemp -p " + reload,
+			}
+
+			ctx := context.Background()
+			r := nginx.NewRegistryFile(cfg)
+			r.Construct(ctx)
+
+			serr := data.(scenario)(ctx, r, cfg)
+
+			entries, err := os.ReadDir(reload)
+			if err != nil {
+				return nil, err
+			}
+			res := result{reloaded: len(entries)}
+
+			res.conf, err = os.ReadFile(filepath.Join(dir, "whoami.conf"))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+
+			return res, serr
+		},
+		assertion: map[string]TestAssertion{
+			"register_reload": {
+				data: func() any {
+					return register("whoami-1", "whoami-2")
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-1:80", "whoami-2:80"}, nginx.ParseServers(res.conf))
+					assert.Equal(t, 2, res.reloaded)
+				},
+			},
+			"check_failed_restore_previous": {
+				data: func() any {
+					return func(ctx context.Context, r *nginx.RegistryFile, cfg *conf.Nginx) error {
+						if err := register("whoami-1")(ctx, r, cfg); err != nil {
+							return err
+						}
+						cfg.CheckCommand = "false"
+						return register("whoami-2")(ctx, r, cfg)
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "false")
+					assert.Equal(t, []string{"whoami-1:80"}, nginx.ParseServers(res.conf))
+					assert.Equal(t, 1, res.reloaded)
+				},
+			},
+			"check_failed_remove_new": {
+				data: func() any {
+					return func(ctx context.Context, r *nginx.RegistryFile, cfg *conf.Nginx) error {
+						cfg.CheckCommand = "false"
+						return register("whoami-1")(ctx, r, cfg)
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.Error(t, err)
+					assert.Nil(t, res.conf)
+					assert.Equal(t, 0, res.reloaded)
+				},
+			},
+			"deregister_last_server": {
+				data: func() any {
+					return func(ctx context.Context, r *nginx.RegistryFile, cfg *conf.Nginx) error {
+						if err := register("whoami-1", "whoami-2")(ctx, r, cfg); err != nil {
+							return err
+						}
+						for _, name := range []string{"whoami-1", "whoami-2", "whoami-2"} {
+							if err := r.Deregister(ctx, newContainer(name)); err != nil {
+								return err
+							}
+						}
+						return nil
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Nil(t, res.conf)
+					// nothing to reload on the second deregister of whoami-2
+					assert.Equal(t, 4, res.reloaded)
+				},
+			},
+			"service_outside_include_dir": {
+				data: func() any {
+					return func(ctx context.Context, r *nginx.RegistryFile, cfg *conf.Nginx) error {
+						cnt := dockertest.Container{Service: "../whoami"}.Build()
+						return r.Register(ctx, cnt)
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.ErrorContains(t, err, "invalid service name")
+					assert.Equal(t, 0, res.reloaded)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package nginx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types"
+)
+
+var (
+	LABEL_TURU_NGINX_SERVER_NAME = "turu.nginx.server_name"
+	LABEL_TURU_NGINX_LISTEN      = "turu.nginx.listen"
+	LABEL_TURU_NGINX_LOCATION    = "turu.nginx.location"
+)
+
+// unsafeChars end directive, open or close block, or start comment when label
+// value is written into nginx configuration
+const unsafeChars = ";{}#\"'\\\r\n"
+
+// Upstream hold data rendered into service configuration file
+type Upstream struct {
+	Name       string
+	Servers    []string
+	ServerName string
+	Listen     string
+	Location   string
+}
+
+var upstreamTemplate = template.Must(template.New("upstream").Parse(`# managed by turu, do not edit
+upstream {{ .Name }} {
+{{- range .Servers }}
+    server {{ . }};
+{{- end }}
+}
+{{- if .ServerName }}
+
+server {
+    listen {{ .Listen }};
+    server_name {{ .ServerName }};
+
+    location {{ .Location }} {
+        proxy_pass http://{{ .Name }};
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+    }
+}
+{{- end }}
+`))
+
+// CreateUpstream create upstream of container service with the given servers,
+// server block is rendered only when turu.nginx.server_name label exist
+func CreateUpstream(service string, servers []string, cnt types.ContainerJSON) (*Upstream, error) {
+	labels := cnt.Config.Labels
+
+	u := &Upstream{
+		Name:       service,
+		Servers:    servers,
+		ServerName: labels[LABEL_TURU_NGINX_SERVER_NAME],
+		Listen:     "80",
+		Location:   "/",
+	}
+
+	if v, ok := labels[LABEL_TURU_NGINX_LISTEN]; ok {
+		u.Listen = v
+	}
+
+	if v, ok := labels[LABEL_TURU_NGINX_LOCATION]; ok {
+		u.Location = v
+	}
+
+	for label, v := range map[string]string{
+		LABEL_TURU_NGINX_SERVER_NAME: u.ServerName,
+		LABEL_TURU_NGINX_LISTEN:      u.Listen,
+		LABEL_TURU_NGINX_LOCATION:    u.Location,
+	} {
+		if strings.ContainsAny(v, unsafeChars) {
+			return nil, fmt.Errorf("%s %q contains character which is not allowed in nginx configuration", label, v)
+		}
+	}
+
+	return u, nil
+}
+
+func (u *Upstream) Render() ([]byte, error) {
+	var b bytes.Buffer
+
+	err := upstreamTemplate.Execute(&b, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// ParseServers read servers of upstream block from file rendered by turu
+func ParseServers(b []byte) []string {
+	var (
+		servers    []string
+		inUpstream bool
+	)
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		switch {
+		case strings.HasPrefix(line, "upstream "):
+			inUpstream = true
+		case inUpstream && line == "}":
+			return servers
+		case inUpstream && strings.HasPrefix(line, "server "):
+			servers = append(servers, strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(line, "server ")), ";"))
+		}
+	}
+
+	return servers
+}
@@ -0,0 +1,95 @@
+package nginx_test
+
+import (
+	"testing"
+
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/nginx"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+func TestRenderUpstream(t *testing.T) {
+	table := TestTable{
+		// data is container labels, rendered configuration is returned
+		test: func(data any) (any, error) {
+			cnt := dockertest.Container{Labels: data.(map[string]string)}.Build()
+
+			u, err := nginx.CreateUpstream("whoami", []string{"whoami-1:80", "whoami-2:80"}, cnt)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := u.Render()
+			return string(b), err
+		},
+		assertion: map[string]TestAssertion{
+			"upstream_only": {
+				data: func() any {
+					return map[string]string{}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, `# managed by turu, do not edit
+upstream whoami {
+    server whoami-1:80;
+    server whoami-2:80;
+}
+`, obj)
+				},
+			},
+			"with_server_block": {
+				data: func() any {
+					return map[string]string{
+						"turu.nginx.server_name": "example.com www.example.com",
+						"turu.nginx.location":    "~ ^/api/v[0-9]+$",
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Contains(t, obj, "server_name example.com www.example.com;")
+					assert.Contains(t, obj, "listen 80;")
+					assert.Contains(t, obj, "location ~ ^/api/v[0-9]+$ {")
+					assert.Contains(t, obj, "proxy_pass http://whoami;")
+
+					// server block does not leak into upstream servers
+					assert.Equal(t, []string{"whoami-1:80", "whoami-2:80"}, nginx.ParseServers([]byte(obj.(string))))
+				},
+			},
+			"server_name_injection": {
+				data: func() any {
+					return map[string]string{"turu.nginx.server_name": "example.com; include /etc/passwd"}
+				},
+				expectation: func(obj any, err error) {
+					assert.ErrorContains(t, err, "turu.nginx.server_name")
+				},
+			},
+			"location_close_block": {
+				data: func() any {
+					return map[string]string{
+						"turu.nginx.server_name": "example.com",
+						"turu.nginx.location":    "/ {}\nserver {",
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.ErrorContains(t, err, "turu.nginx.location")
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
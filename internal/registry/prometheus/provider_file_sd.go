@@ -0,0 +1,156 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/goccy/go-yaml"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/fileutil"
+)
+
+// RegistryFileSD maintain one target group per container in prometheus
+// file_sd_configs file, prometheus watch the file and reload targets itself
+type RegistryFileSD struct {
+	cfg *conf.PrometheusFileSD
+	m   *sync.Mutex
+}
+
+func NewRegistryFileSD(cfg *conf.PrometheusFileSD) *RegistryFileSD {
+	return &RegistryFileSD{cfg: cfg}
+}
+
+func (p *RegistryFileSD) Construct(ctx context.Context) {
+	if p.m == nil {
+		p.m = &sync.Mutex{}
+	}
+}
+
+func isYaml(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func (p *RegistryFileSD) path() (string, error) {
+	if p.cfg == nil || p.cfg.Path == "" {
+		return "", errors.New("prometheus-file-sd.path could not be empty")
+	}
+
+	return p.cfg.Path, nil
+}
+
+// readTargets read target groups, missing file treated as empty
+func (p *RegistryFileSD) readTargets(path string) ([]*TargetGroup, error) {
+	var tgs []*TargetGroup
+
+	f, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tgs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if isYaml(path) {
+		err = yaml.Unmarshal(f, &tgs)
+	} else {
+		err = json.Unmarshal(f, &tgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tgs, nil
+}
+
+func (p *RegistryFileSD) writeTargets(path string, tgs []*TargetGroup) error {
+	var (
+		s   []byte
+		err error
+	)
+
+	// keep output stable so unchanged registration does not rewrite the file
+	slices.SortFunc(tgs, func(a, b *TargetGroup) int {
+		return strings.Compare(a.Labels["container"], b.Labels["container"])
+	})
+
+	// prometheus expect list, never null
+	if tgs == nil {
+		tgs = []*TargetGroup{}
+	}
+
+	if isYaml(path) {
+		s, err = yaml.Marshal(tgs)
+	} else {
+		s, err = json.MarshalIndent(tgs, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(path, s, 0644)
+}
+
+func (p *RegistryFileSD) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path, err := p.path()
+	if err != nil {
+		return err
+	}
+
+	tgs, err := p.readTargets(path)
+	if err != nil {
+		return err
+	}
+
+	tg, err := CreateTargetGroup(c)
+	if err != nil {
+		return err
+	}
+
+	// replace target group of the same container
+	tgs = slices.DeleteFunc(tgs, func(v *TargetGroup) bool {
+		return v.Labels["container"] == tg.Labels["container"]
+	})
+	tgs = append(tgs, tg)
+
+	return p.writeTargets(path, tgs)
+}
+
+func (p *RegistryFileSD) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path, err := p.path()
+	if err != nil {
+		return err
+	}
+
+	tgs, err := p.readTargets(path)
+	if err != nil {
+		return err
+	}
+
+	name, _ := docker.GetContainerOrServiceName(c)
+
+	n := len(tgs)
+	tgs = slices.DeleteFunc(tgs, func(v *TargetGroup) bool {
+		return v.Labels["container"] == name
+	})
+
+	if len(tgs) == n {
+		return nil
+	}
+
+	return p.writeTargets(path, tgs)
+}
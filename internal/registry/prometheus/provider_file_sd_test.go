@@ -0,0 +1,171 @@
+package prometheus_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/goccy/go-yaml"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is file extension and registry calls of single test case
+type scenario struct {
+	ext string
+	run func(ctx context.Context, r *prometheus.RegistryFileSD) error
+}
+
+func newContainer(name string) types.ContainerJSON {
+	return dockertest.Container{
+		Name: name,
+		Labels: map[string]string{
+			"turu.prometheus.port":        "9090",
+			"turu.prometheus.path":        "/internal/metrics",
+			"com.example.team":            "platform",
+			"org.opencontainers.image.id": "abc",
+		},
+	}.Build()
+}
+
+func register(ctx context.Context, r *prometheus.RegistryFileSD) error {
+	for _, name := range []string{"whoami-2", "whoami-1", "whoami-1"} {
+		if err := r.Register(ctx, newContainer(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deregister(ctx context.Context, r *prometheus.RegistryFileSD) error {
+	if err := register(ctx, r); err != nil {
+		return err
+	}
+
+	// the last deregister is already deregistered
+	for _, name := range []string{"whoami-1", "whoami-2", "whoami-2"} {
+		if err := r.Deregister(ctx, newContainer(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCreateTargetGroup(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			return prometheus.CreateTargetGroup(data.(types.ContainerJSON))
+		},
+		assertion: map[string]TestAssertion{
+			"labels": {
+				data: func() any {
+					return newContainer("whoami-1")
+				},
+				expectation: func(obj any, err error) {
+					tg := obj.(*prometheus.TargetGroup)
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-1:9090"}, tg.Targets)
+					assert.Equal(t, map[string]string{
+						"container":                        "whoami-1",
+						"service":                          "whoami",
+						"__metrics_path__":                 "/internal/metrics",
+						"container_label_com_example_team": "platform",
+						"container_label_org_opencontainers_image_id": "abc",
+					}, tg.Labels)
+				},
+			},
+			"lowest_exposed_port": {
+				data: func() any {
+					return dockertest.Container{Ports: []string{"8080/tcp", "80/tcp"}}.Build()
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-1:80"}, obj.(*prometheus.TargetGroup).Targets)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
+
+func TestRegistryFileSD(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			sc := data.(scenario)
+			path := filepath.Join(t.TempDir(), "targets"+sc.ext)
+
+			ctx := context.Background()
+			r := prometheus.NewRegistryFileSD(&conf.PrometheusFileSD{Path: path})
+			r.Construct(ctx)
+
+			if err := sc.run(ctx, r); err != nil {
+				return nil, err
+			}
+
+			var tgs []prometheus.TargetGroup
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if sc.ext == ".json" {
+				err = json.Unmarshal(b, &tgs)
+			} else {
+				err = yaml.Unmarshal(b, &tgs)
+			}
+
+			return tgs, err
+		},
+		assertion: map[string]TestAssertion{},
+	}
+
+	for _, ext := range []string{".json", ".yaml"} {
+		table.assertion["register"+ext] = TestAssertion{
+			data: func() any {
+				return scenario{ext: ext, run: register}
+			},
+			expectation: func(obj any, err error) {
+				tgs := obj.([]prometheus.TargetGroup)
+				assert.NoError(t, err)
+				// register twice replace the target group
+				assert.Len(t, tgs, 2)
+				assert.Equal(t, []string{"whoami-1:9090"}, tgs[0].Targets)
+				assert.Equal(t, []string{"whoami-2:9090"}, tgs[1].Targets)
+			},
+		}
+		table.assertion["deregister"+ext] = TestAssertion{
+			data: func() any {
+				return scenario{ext: ext, run: deregister}
+			},
+			expectation: func(obj any, err error) {
+				assert.NoError(t, err)
+				assert.Empty(t, obj)
+			},
+		}
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+var (
+	LABEL_TURU_PROMETHEUS_PORT = "turu.prometheus.port"
+	LABEL_TURU_PROMETHEUS_PATH = "turu.prometheus.path"
+
+	// prefix of target label holding container label, the prefix keep label
+	// name valid and away from labels set by prometheus
+	CONTAINER_LABEL_PREFIX = "container_label_"
+)
+
+var invalidLabelChar = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// TargetGroup is single entry of prometheus file_sd_configs file
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// CreateTargetGroup create target group of container metrics endpoint, port
+// default to the lowest exposed port when turu.prometheus.port is not set
+func CreateTargetGroup(cnt types.ContainerJSON) (*TargetGroup, error) {
+	name, service := docker.GetContainerOrServiceName(cnt)
+	labels := cnt.Config.Labels
+
+	port := labels[LABEL_TURU_PROMETHEUS_PORT]
+	if port == "" {
+		var ports []int
+		for p := range cnt.Config.ExposedPorts {
+			ports = append(ports, p.Int())
+		}
+		if len(ports) == 0 {
+			return nil, errors.New("container has no exposed port and turu.prometheus.port is not set")
+		}
+		port = strconv.Itoa(slices.Min(ports))
+	}
+
+	tg := &TargetGroup{
+		Targets: []string{fmt.Sprintf("%s:%s", name, port)},
+		Labels: map[string]string{
+			"container": name,
+			"service":   service,
+		},
+	}
+
+	if path := labels[LABEL_TURU_PROMETHEUS_PATH]; path != "" {
+		tg.Labels["__metrics_path__"] = path
+	}
+
+	for k, v := range labels {
+		if strings.HasPrefix(k, "turu.") {
+			continue
+		}
+		tg.Labels[CONTAINER_LABEL_PREFIX+SanitizeLabelName(k)] = v
+	}
+
+	return tg, nil
+}
+
+// SanitizeLabelName replace character not allowed in prometheus label name
+// with underscore
+func SanitizeLabelName(name string) string {
+	return invalidLabelChar.ReplaceAllString(name, "_")
+}
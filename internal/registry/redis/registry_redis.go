@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultPrefix = "turu"
+	defaultTTL    = 30 * time.Second
+)
+
+// Notification is published on <prefix>:events channel on every change
+type Notification struct {
+	Event   string   `json:"event"`
+	Service string   `json:"service"`
+	Nodes   []string `json:"nodes"`
+}
+
+// RegistryRedis store nodes of service in sorted set <prefix>:service:<service>
+// scored by expiry unix time. Nodes registered by this turu are refreshed
+// periodically, nodes left by crashed turu expire once their score passed.
+type RegistryRedis struct {
+	cfg    *conf.Redis
+	rc     *redis.Client
+	prefix string
+	ttl    time.Duration
+
+	m     *sync.Mutex
+	nodes map[string]map[string][]string
+}
+
+func NewRegistryRedis(cfg *conf.Redis) *RegistryRedis {
+	return &RegistryRedis{cfg: cfg}
+}
+
+func (p *RegistryRedis) Construct(ctx context.Context) {
+	if p.rc != nil {
+		return
+	}
+
+	cfg := p.cfg
+	if cfg == nil || cfg.Address == "" {
+		log.Fatal().Msg("redis.address could not be empty")
+	}
+
+	p.rc = redis.NewClient(&redis.Options{
+		Addr:     cfg.Address,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	p.prefix, p.ttl = cfg.Prefix, cfg.TTL
+	if p.prefix == "" {
+		p.prefix = defaultPrefix
+	}
+	if p.ttl == 0 {
+		p.ttl = defaultTTL
+	}
+
+	p.m = &sync.Mutex{}
+	p.nodes = make(map[string]map[string][]string)
+
+	go func() {
+		t := time.NewTicker(p.ttl / 3)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			err := p.Refresh(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to refresh redis nodes")
+			}
+		}
+	}()
+}
+
+func (p *RegistryRedis) serviceKey(service string) string {
+	return fmt.Sprintf("%s:service:%s", p.prefix, service)
+}
+
+func (p *RegistryRedis) servicesKey() string {
+	return p.prefix + ":services"
+}
+
+func (p *RegistryRedis) channel() string {
+	return p.prefix + ":events"
+}
+
+func (p *RegistryRedis) expiry() float64 {
+	return float64(time.Now().Add(p.ttl).Unix())
+}
+
+func (p *RegistryRedis) publish(ctx context.Context, event string, service string, nodes []string) error {
+	b, err := json.Marshal(Notification{Event: event, Service: service, Nodes: nodes})
+	if err != nil {
+		return err
+	}
+
+	return p.rc.Publish(ctx, p.channel(), b).Err()
+}
+
+func (p *RegistryRedis) Register(ctx context.Context, c types.ContainerJSON) error {
+	name, service := docker.GetContainerOrServiceName(c)
+
+	var nodes []string
+	for _, v := range docker.GetLoadBalancerURL(name, c) {
+		if v != "" {
+			nodes = append(nodes, v)
+		}
+	}
+
+	score := p.expiry()
+	members := make([]redis.Z, 0, len(nodes))
+	for _, n := range nodes {
+		members = append(members, redis.Z{Score: score, Member: n})
+	}
+
+	_, err := p.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, p.serviceKey(service), members...)
+		pipe.SAdd(ctx, p.servicesKey(), service)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	if _, ok := p.nodes[service]; !ok {
+		p.nodes[service] = make(map[string][]string)
+	}
+	p.nodes[service][c.ID] = nodes
+	p.m.Unlock()
+
+	return p.publish(ctx, "register", service, nodes)
+}
+
+func (p *RegistryRedis) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	name, service := docker.GetContainerOrServiceName(c)
+
+	p.m.Lock()
+	delete(p.nodes[service], c.ID)
+	if len(p.nodes[service]) == 0 {
+		delete(p.nodes, service)
+	}
+	p.m.Unlock()
+
+	var members []any
+	for _, v := range docker.GetLoadBalancerURL(name, c) {
+		if v != "" {
+			members = append(members, v)
+		}
+	}
+
+	removed, err := p.rc.ZRem(ctx, p.serviceKey(service), members...).Result()
+	if err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		return nil
+	}
+
+	err = p.removeEmptyService(ctx, service)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]string, 0, len(members))
+	for _, m := range members {
+		nodes = append(nodes, m.(string))
+	}
+
+	return p.publish(ctx, "deregister", service, nodes)
+}
+
+// removeEmptyService remove service from services set once its last node gone
+func (p *RegistryRedis) removeEmptyService(ctx context.Context, service string) error {
+	n, err := p.rc.ZCard(ctx, p.serviceKey(service)).Result()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	return p.rc.SRem(ctx, p.servicesKey(), service).Err()
+}
+
+// Refresh extend expiry of nodes registered by this turu and remove expired
+// nodes of every service
+func (p *RegistryRedis) Refresh(ctx context.Context) error {
+	p.m.Lock()
+	score := p.expiry()
+	members := make(map[string][]redis.Z, len(p.nodes))
+	for service, cnts := range p.nodes {
+		for _, nodes := range cnts {
+			for _, n := range nodes {
+				members[service] = append(members[service], redis.Z{Score: score, Member: n})
+			}
+		}
+	}
+	p.m.Unlock()
+
+	// nodes are added back when redis lost its data, node deregistered while
+	// refreshing expire after one ttl
+	for service, z := range members {
+		_, err := p.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, p.serviceKey(service), z...)
+			pipe.SAdd(ctx, p.servicesKey(), service)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	services, err := p.rc.SMembers(ctx, p.servicesKey()).Result()
+	if err != nil {
+		return err
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, service := range services {
+		expired, err := p.rc.ZRangeByScore(ctx, p.serviceKey(service), &redis.ZRangeBy{Min: "-inf", Max: "(" + now}).Result()
+		if err != nil {
+			return err
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		err = p.rc.ZRemRangeByScore(ctx, p.serviceKey(service), "-inf", "("+now).Err()
+		if err != nil {
+			return err
+		}
+
+		err = p.removeEmptyService(ctx, service)
+		if err != nil {
+			return err
+		}
+
+		log.Ctx(ctx).Info().Str("service", service).Strs("nodes", expired).Msg("expired redis nodes removed")
+
+		err = p.publish(ctx, "expire", service, expired)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
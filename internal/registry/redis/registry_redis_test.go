@@ -0,0 +1,189 @@
+package redis_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	tururedis "github.com/praswicaksono/turu/internal/registry/redis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is registry calls of single test case, prefix is "app"
+type scenario = func(ctx context.Context, r *tururedis.RegistryRedis, mr *miniredis.Miniredis) error
+
+// result is redis state and notifications published by scenario
+type result struct {
+	rc            *redis.Client
+	notifications []tururedis.Notification
+}
+
+func newContainer(name string) types.ContainerJSON {
+	return dockertest.Container{Name: name}.Build()
+}
+
+func register(names ...string) scenario {
+	return func(ctx context.Context, r *tururedis.RegistryRedis, mr *miniredis.Miniredis) error {
+		for _, name := range names {
+			if err := r.Register(ctx, newContainer(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestRegistryRedis(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			mr := miniredis.RunT(t)
+			ctx := context.Background()
+			rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			sub := rc.Subscribe(ctx, "app:events")
+			defer sub.Close()
+			if _, err := sub.Receive(ctx); err != nil {
+				return nil, err
+			}
+
+			r := tururedis.NewRegistryRedis(&conf.Redis{Address: mr.Addr(), Prefix: "app", TTL: time.Minute})
+			r.Construct(ctx)
+
+			err := data.(scenario)(ctx, r, mr)
+
+			res := result{rc: rc}
+			for {
+				msg, err := sub.ReceiveTimeout(ctx, 100*time.Millisecond)
+				if err != nil {
+					break
+				}
+				var n tururedis.Notification
+				if m, ok := msg.(*redis.Message); ok && json.Unmarshal([]byte(m.Payload), &n) == nil {
+					res.notifications = append(res.notifications, n)
+				}
+			}
+
+			return res, err
+		},
+		assertion: map[string]TestAssertion{
+			"register": {
+				data: func() any {
+					return register("whoami-1", "whoami-2")
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					ctx := context.Background()
+					assert.NoError(t, err)
+					assert.Equal(t, []tururedis.Notification{
+						{Event: "register", Service: "whoami", Nodes: []string{"whoami-1:80"}},
+						{Event: "register", Service: "whoami", Nodes: []string{"whoami-2:80"}},
+					}, res.notifications)
+
+					nodes, err := res.rc.ZRangeByScore(ctx, "app:service:whoami", &redis.ZRangeBy{Min: "-inf", Max: "+inf"}).Result()
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-1:80", "whoami-2:80"}, nodes)
+
+					score, err := res.rc.ZScore(ctx, "app:service:whoami", "whoami-1:80").Result()
+					assert.NoError(t, err)
+					assert.InDelta(t, float64(time.Now().Add(time.Minute).Unix()), score, 2)
+				},
+			},
+			"deregister": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *tururedis.RegistryRedis, mr *miniredis.Miniredis) error {
+						if err := register("whoami-1", "whoami-2")(ctx, r, mr); err != nil {
+							return err
+						}
+						// the second deregister publish nothing
+						for _, name := range []string{"whoami-1", "whoami-1", "whoami-2"} {
+							if err := r.Deregister(ctx, newContainer(name)); err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					assert.NoError(t, err)
+					assert.Len(t, res.notifications, 4)
+					assert.Equal(t, tururedis.Notification{Event: "deregister", Service: "whoami", Nodes: []string{"whoami-1:80"}}, res.notifications[2])
+					assert.Equal(t, "deregister", res.notifications[3].Event)
+
+					services, err := res.rc.SMembers(context.Background(), "app:services").Result()
+					assert.NoError(t, err)
+					assert.Empty(t, services)
+				},
+			},
+			"refresh_remove_stale": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *tururedis.RegistryRedis, mr *miniredis.Miniredis) error {
+						if err := register("whoami-1")(ctx, r, mr); err != nil {
+							return err
+						}
+
+						// node left by crashed turu
+						stale := float64(time.Now().Add(-time.Second).Unix())
+						mr.ZAdd("app:service:whoami", stale, "whoami-9:80")
+						mr.ZAdd("app:service:gone", stale, "gone-1:80")
+						mr.SAdd("app:services", "gone")
+
+						return r.Refresh(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					ctx := context.Background()
+					assert.NoError(t, err)
+
+					nodes, err := res.rc.ZRange(ctx, "app:service:whoami", 0, -1).Result()
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-1:80"}, nodes)
+
+					services, err := res.rc.SMembers(ctx, "app:services").Result()
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami"}, services)
+				},
+			},
+			"refresh_after_data_lost": {
+				data: func() any {
+					return scenario(func(ctx context.Context, r *tururedis.RegistryRedis, mr *miniredis.Miniredis) error {
+						if err := register("whoami-1")(ctx, r, mr); err != nil {
+							return err
+						}
+						mr.FlushAll()
+						return r.Refresh(ctx)
+					})
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+
+					nodes, err := obj.(result).rc.ZRange(context.Background(), "app:service:whoami", 0, -1).Result()
+					assert.NoError(t, err)
+					assert.Equal(t, []string{"whoami-1:80"}, nodes)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -3,27 +3,156 @@ package registry
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/gookit/goutil"
+	"github.com/praswicaksono/turu/internal/conf"
 	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/metrics"
 	"github.com/praswicaksono/turu/internal/registry/apisix"
+	"github.com/praswicaksono/turu/internal/registry/caddy"
+	"github.com/praswicaksono/turu/internal/registry/consul"
+	"github.com/praswicaksono/turu/internal/registry/dns"
+	"github.com/praswicaksono/turu/internal/registry/envoy"
+	"github.com/praswicaksono/turu/internal/registry/etcd"
+	"github.com/praswicaksono/turu/internal/registry/eureka"
+	"github.com/praswicaksono/turu/internal/registry/haproxy"
+	"github.com/praswicaksono/turu/internal/registry/hosts"
+	"github.com/praswicaksono/turu/internal/registry/kong"
+	"github.com/praswicaksono/turu/internal/registry/nacos"
+	"github.com/praswicaksono/turu/internal/registry/nginx"
+	"github.com/praswicaksono/turu/internal/registry/prometheus"
+	"github.com/praswicaksono/turu/internal/registry/redis"
+	"github.com/praswicaksono/turu/internal/registry/traefik"
+	"github.com/praswicaksono/turu/internal/registry/webhook"
+	"github.com/praswicaksono/turu/internal/registry/zookeeper"
 	"github.com/rs/zerolog/log"
 )
 
 type RegistryCollection = map[string]Registry
 
-var availableRegistry = RegistryCollection{
-	"apisix-yaml": &apisix.RegistryYaml{},
-	"apisix-etcd": &apisix.RegistryEtcd{},
+// availableRegistry is registry instances keyed by name referred in
+// turu.registry label, it is built from config by Init
+var availableRegistry = RegistryCollection{}
+
+// newRegistry create registry from its options
+type newRegistry = func(options map[string]any) (Registry, error)
+
+// registryTypes is every supported registry type
+var registryTypes = map[string]newRegistry{
+	"apisix-yaml":        create(apisix.NewRegistryYaml),
+	"apisix-etcd":        create(apisix.NewRegistryEtcd),
+	"apisix-admin":       create(apisix.NewRegistryAdmin),
+	"consul":             create(consul.NewRegistryConsul),
+	"traefik-file":       create(traefik.NewRegistryFile),
+	"nginx":              create(nginx.NewRegistryFile),
+	"envoy-xds":          create(envoy.NewRegistryXDS),
+	"haproxy":            create(haproxy.NewRegistryRuntime),
+	"caddy":              create(caddy.NewRegistryAdmin),
+	"prometheus-file-sd": create(prometheus.NewRegistryFileSD),
+	"dns":                create(dns.NewRegistryDNS),
+	"hosts-file":         create(hosts.NewRegistryFile),
+	"webhook":            create(webhook.NewRegistryWebhook),
+	"redis":              create(redis.NewRegistryRedis),
+	"etcd":               create(etcd.NewRegistryEtcd),
+	"kong-yaml":          create(kong.NewRegistryYaml),
+	"zookeeper":          create(zookeeper.NewRegistryZookeeper),
+	"eureka":             create(eureka.NewRegistryEureka),
+	"nacos":              create(nacos.NewRegistryNacos),
+}
+
+func create[C any, R Registry](fn func(cfg *C) R) newRegistry {
+	return func(options map[string]any) (Registry, error) {
+		cfg := new(C)
+		err := conf.DecodeOptions(options, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return fn(cfg), nil
+	}
 }
 
+// Init build available registry from config. Registry type configured by
+// legacy config section is available under its own name, named instances
+// under registries section are added on top and may shadow them. Registry
+// which is not configured is not available, containers referring to it are
+// skipped. Every registry is constructed once with ctx, which bound the
+// lifetime of its background work, before any container event is handled.
+func Init(ctx context.Context, cfg *conf.Turu) error {
+	rs := RegistryCollection{}
+
+	for t, fn := range registryTypes {
+		options, ok := cfg.Config[t]
+		if !ok {
+			continue
+		}
+		if options == nil {
+			options = map[string]any{}
+		}
+
+		r, err := fn(options)
+		if err != nil {
+			return fmt.Errorf("config.%s: %w", t, err)
+		}
+		rs[t] = r
+	}
+
+	for name, instance := range cfg.Registries {
+		fn, ok := registryTypes[instance.Type]
+		if !ok {
+			return fmt.Errorf("registries.%s: unknown registry type %q", name, instance.Type)
+		}
+
+		options := instance.Options
+		if options == nil {
+			options = map[string]any{}
+		}
+
+		r, err := fn(options)
+		if err != nil {
+			return fmt.Errorf("registries.%s: %w", name, err)
+		}
+		rs[name] = r
+	}
+
+	for name, r := range rs {
+		r.Construct(log.Ctx(ctx).With().Str("registry", name).Logger().WithContext(ctx))
+	}
+
+	availableRegistry = rs
+
+	return nil
+}
+
+// Registry receives container lifecycle events. A container may send several
+// stopping events (kill, die, stop, destroy), so Deregister of a container
+// which is not registered anymore must succeed without doing anything.
 type Registry interface {
 	Register(ctx context.Context, c types.ContainerJSON) error
 	Deregister(ctx context.Context, c types.ContainerJSON) error
+	// Construct is called once by Init, background work started by registry
+	// must stop when ctx is done
 	Construct(ctx context.Context)
 }
 
+// Drainer is implemented by registry which able to stop routing new request to
+// container nodes without removing them, it returns false when there is no
+// node to drain
+type Drainer interface {
+	Drain(ctx context.Context, c types.ContainerJSON) (bool, error)
+}
+
+// Syncer is implemented by registry which able to converge its state to the
+// given containers listed at listedAt, it returns number of corrections made.
+// Changes made by container events after listedAt must be left as is.
+type Syncer interface {
+	Sync(ctx context.Context, cnts []types.ContainerJSON, listedAt time.Time) (int, error)
+}
+
 func isValidRegistry(ctx context.Context, p string) error {
 	if !goutil.Contains(availableRegistry, p) {
 		err := errors.New("invalid turu registry, skiping registation")
@@ -33,40 +162,255 @@ func isValidRegistry(ctx context.Context, p string) error {
 
 	return nil
 }
-func HandleContainerCreateEvent(ctx context.Context, cnt types.ContainerJSON) error {
-	p := docker.GetRegistry(cnt)
 
-	// if registry label not found skip then process
-	err := isValidRegistry(ctx, p)
-	if err != nil {
-		return nil
+// serviceLocks serialize registry calls of the same service. Container events
+// are already ordered per service by dispatcher, but deregistration of drained
+// container happen outside of it.
+var serviceLocks = struct {
+	m     sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+func lockService(cnt types.ContainerJSON) func() {
+	_, service := docker.GetContainerOrServiceName(cnt)
+
+	serviceLocks.m.Lock()
+	l, ok := serviceLocks.locks[service]
+	if !ok {
+		l = &sync.Mutex{}
+		serviceLocks.locks[service] = l
 	}
+	serviceLocks.m.Unlock()
 
-	availableRegistry[p].Construct(ctx)
-	err = availableRegistry[p].Register(ctx, cnt)
-	if err != nil {
-		return err
+	l.Lock()
+	return l.Unlock
+}
+
+// forEachRegistry call fn for every valid registry of container with logger
+// of that registry, failure of one registry does not stop the others and is
+// returned prefixed with the registry name
+func forEachRegistry(ctx context.Context, cnt types.ContainerJSON, fn func(ctx context.Context, r Registry) error) error {
+	defer lockService(cnt)()
+
+	var errs []error
+	for _, p := range docker.GetRegistry(cnt) {
+		rctx := log.Ctx(ctx).With().Str("registry", p).Logger().WithContext(ctx)
+
+		// if registry is unknown skip it
+		err := isValidRegistry(rctx, p)
+		if err != nil {
+			continue
+		}
+
+		err = fn(rctx, availableRegistry[p])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
 	}
 
-	log.Ctx(ctx).Info().Msg("container successfully registered")
-	return nil
+	return errors.Join(errs...)
+}
+
+func HandleContainerCreateEvent(ctx context.Context, cnt types.ContainerJSON) error {
+	// container started again while draining keep its nodes
+	if cancelDrain(cnt.ID) {
+		log.Ctx(ctx).Info().Msg("container registered again, pending deregistration cancelled")
+	}
+
+	return forEachRegistry(ctx, cnt, func(ctx context.Context, r Registry) error {
+		err := r.Register(ctx, cnt)
+		if err != nil {
+			return err
+		}
+
+		log.Ctx(ctx).Info().Msg("container successfully registered")
+		return nil
+	})
 }
 
 func HandleContainerKillEvent(ctx context.Context, cnt types.ContainerJSON) error {
-	p := docker.GetRegistry(cnt)
+	cancelDrain(cnt.ID)
+
+	return deregister(ctx, cnt)
+}
+
+func deregister(ctx context.Context, cnt types.ContainerJSON) error {
+	return forEachRegistry(ctx, cnt, func(ctx context.Context, r Registry) error {
+		err := r.Deregister(ctx, cnt)
+		if err != nil {
+			return err
+		}
 
-	// if registry label not found skip then process
-	err := isValidRegistry(ctx, p)
-	if err != nil {
+		log.Ctx(ctx).Info().Msg("container successfully deregistered")
 		return nil
+	})
+}
+
+// pendingDrain is deregistration of drained container waiting for its period
+type pendingDrain struct {
+	m         sync.Mutex
+	timer     *time.Timer
+	cancelled bool
+}
+
+// drains is pending deregistration keyed by container ID
+var drains = struct {
+	m       sync.Mutex
+	pending map[string]*pendingDrain
+}{pending: make(map[string]*pendingDrain)}
+
+// cancelDrain stop pending deregistration of container, it waits for the
+// deregistration when it is already running. It returns false when nothing
+// was pending.
+func cancelDrain(id string) bool {
+	drains.m.Lock()
+	p, ok := drains.pending[id]
+	delete(drains.pending, id)
+	drains.m.Unlock()
+
+	if !ok {
+		return false
 	}
 
-	availableRegistry[p].Construct(ctx)
-	err = availableRegistry[p].Deregister(ctx, cnt)
-	if err != nil {
-		return err
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.cancelled = true
+	p.timer.Stop()
+
+	return true
+}
+
+// isDraining check whether deregistration of container is pending
+func isDraining(id string) bool {
+	drains.m.Lock()
+	defer drains.m.Unlock()
+
+	_, ok := drains.pending[id]
+	return ok
+}
+
+// scheduleDrain deregister container once period passed unless cancelled
+func scheduleDrain(ctx context.Context, cnt types.ContainerJSON, period time.Duration) {
+	cancelDrain(cnt.ID)
+
+	p := &pendingDrain{}
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	drains.m.Lock()
+	drains.pending[cnt.ID] = p
+	drains.m.Unlock()
+
+	p.timer = time.AfterFunc(period, func() {
+		p.m.Lock()
+		defer p.m.Unlock()
+
+		if p.cancelled || ctx.Err() != nil {
+			return
+		}
+
+		drains.m.Lock()
+		if drains.pending[cnt.ID] == p {
+			delete(drains.pending, cnt.ID)
+		}
+		drains.m.Unlock()
+
+		err := deregister(ctx, cnt)
+		if err != nil {
+			log.Ctx(ctx).Error().Stack().Err(err).Msg("")
+		}
+	})
+}
+
+// HandleContainerDrainEvent drain container nodes and deregister it once the
+// given period passed, without blocking other events. Registry which does not
+// support draining deregister container right away. Every registry is drained
+// first so the period is waited only once. Pending deregistration is
+// cancelled when the container is registered or deregistered by another event.
+func HandleContainerDrainEvent(ctx context.Context, cnt types.ContainerJSON, period time.Duration) error {
+	var drained bool
+
+	if period > 0 {
+		err := forEachRegistry(ctx, cnt, func(ctx context.Context, r Registry) error {
+			d, ok := r.(Drainer)
+			if !ok {
+				return nil
+			}
+
+			// false when already drained or deregistered by previous event
+			ok, err := d.Drain(ctx, cnt)
+			drained = drained || ok
+
+			return err
+		})
+
+		// container is deregistered anyway, drain failure only shorten its grace
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to drain container")
+		}
 	}
 
-	log.Ctx(ctx).Info().Msg("container successfully deregistered")
-	return nil
+	if drained {
+		log.Ctx(ctx).Info().Dur("period", period).Msg("container drained, deregistering after period")
+		scheduleDrain(ctx, cnt, period)
+		return nil
+	}
+
+	// drained by previous event, e.g. kill followed by stop
+	if isDraining(cnt.ID) {
+		return nil
+	}
+
+	return HandleContainerKillEvent(ctx, cnt)
+}
+
+// Reconcile register running containers then converge every registry to them,
+// so nodes of containers which no longer exist get removed
+func Reconcile(ctx context.Context, cnts []types.ContainerJSON) {
+	for _, cnt := range cnts {
+		cctx := log.Ctx(ctx).With().
+			Str("container_id", cnt.ID).
+			Str("name", cnt.Name).
+			Logger().WithContext(ctx)
+
+		err := HandleContainerCreateEvent(cctx, cnt)
+		if err != nil {
+			log.Ctx(cctx).Error().Stack().Err(err).Msg("")
+		}
+	}
+
+	// nothing else change registries while reconciling, registrations above
+	// are made from the same container list
+	Resync(ctx, cnts, time.Now())
+}
+
+// Resync converge every registry which implement Syncer to the given containers
+// listed at listedAt
+func Resync(ctx context.Context, cnts []types.ContainerJSON, listedAt time.Time) {
+	perRegistry := make(map[string][]types.ContainerJSON)
+	for _, cnt := range cnts {
+		for _, p := range docker.GetRegistry(cnt) {
+			perRegistry[p] = append(perRegistry[p], cnt)
+		}
+	}
+
+	for k, r := range availableRegistry {
+		sr, ok := r.(Syncer)
+		if !ok {
+			continue
+		}
+
+		rctx := log.Ctx(ctx).With().Str("registry", k).Logger().WithContext(ctx)
+		n, err := sr.Sync(rctx, perRegistry[k], listedAt)
+		if n > 0 {
+			metrics.ResyncCorrections.Add(k, int64(n))
+			log.Ctx(rctx).Info().Int("corrections", n).Msg("registry converged")
+		}
+		if err != nil {
+			log.Ctx(rctx).Error().Stack().Err(err).Msg("")
+		}
+	}
+
+	metrics.ResyncRuns.Add(1)
 }
@@ -0,0 +1,253 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/apisix"
+	"github.com/praswicaksono/turu/internal/registry/prometheus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// fakeRegistry count calls, deregistration of drained container is counted
+// from another goroutine
+type fakeRegistry struct {
+	err          error
+	registered   atomic.Int32
+	deregistered atomic.Int32
+}
+
+func (f *fakeRegistry) Construct(ctx context.Context) {}
+
+func (f *fakeRegistry) Register(ctx context.Context, c types.ContainerJSON) error {
+	f.registered.Add(1)
+	return f.err
+}
+
+func (f *fakeRegistry) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	f.deregistered.Add(1)
+	return f.err
+}
+
+// fakeDrainer drain container once, later drain find nothing to drain
+type fakeDrainer struct {
+	fakeRegistry
+	drained atomic.Int32
+}
+
+func (f *fakeDrainer) Drain(ctx context.Context, c types.ContainerJSON) (bool, error) {
+	return f.drained.Add(1) == 1, nil
+}
+
+func withRegistries(t *testing.T, r RegistryCollection) {
+	prev := availableRegistry
+	availableRegistry = r
+	t.Cleanup(func() { availableRegistry = prev })
+}
+
+func newContainer(registry string) types.ContainerJSON {
+	return dockertest.Container{Labels: map[string]string{"turu.registry": registry}}.Build()
+}
+
+func TestHandleContainerCreateEventFanOut(t *testing.T) {
+	failing := &fakeRegistry{err: errors.New("unreachable")}
+	first := &fakeRegistry{}
+	last := &fakeRegistry{}
+	withRegistries(t, RegistryCollection{"first": first, "failing": failing, "last": last})
+
+	cnt := newContainer("first,failing,unknown,last")
+
+	err := HandleContainerCreateEvent(context.Background(), cnt)
+	assert.EqualError(t, err, "failing: unreachable")
+	assert.EqualValues(t, 1, first.registered.Load())
+	assert.EqualValues(t, 1, failing.registered.Load())
+	// failure of one registry does not block the others
+	assert.EqualValues(t, 1, last.registered.Load())
+
+	err = HandleContainerKillEvent(context.Background(), cnt)
+	assert.EqualError(t, err, "failing: unreachable")
+	assert.EqualValues(t, 1, first.deregistered.Load())
+	assert.EqualValues(t, 1, last.deregistered.Load())
+}
+
+func TestHandleContainerDrainEventWaitOnce(t *testing.T) {
+	a := &fakeDrainer{}
+	b := &fakeDrainer{}
+	plain := &fakeRegistry{}
+	withRegistries(t, RegistryCollection{"a": a, "b": b, "plain": plain})
+
+	start := time.Now()
+	err := HandleContainerDrainEvent(context.Background(), newContainer("a,b,plain"), 50*time.Millisecond)
+
+	// deregister is scheduled, the event returns right away
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, plain.deregistered.Load())
+
+	// deregister happen after the grace period, each drainer drained once
+	assert.Eventually(t, func() bool {
+		return plain.deregistered.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.EqualValues(t, 1, a.drained.Load())
+	assert.EqualValues(t, 1, b.drained.Load())
+	assert.EqualValues(t, 1, a.deregistered.Load())
+	assert.EqualValues(t, 1, b.deregistered.Load())
+}
+
+func TestHandleContainerDrainEventPending(t *testing.T) {
+	const period = 20 * time.Millisecond
+
+	table := TestTable{
+		// data is events of the same container after it is drained, result is
+		// deregistration count once period passed
+		test: func(data any) (any, error) {
+			r := &fakeDrainer{}
+			withRegistries(t, RegistryCollection{"a": r})
+
+			ctx := context.Background()
+			cnt := newContainer("a")
+			if err := HandleContainerDrainEvent(ctx, cnt, period); err != nil {
+				return nil, err
+			}
+
+			if err := data.(func(ctx context.Context, cnt types.ContainerJSON) error)(ctx, cnt); err != nil {
+				return nil, err
+			}
+
+			// leave time for cancelled deregistration to happen
+			time.Sleep(5 * period)
+			return r.deregistered.Load(), nil
+		},
+		assertion: map[string]TestAssertion{
+			"registered_again": {
+				data: func() any {
+					return HandleContainerCreateEvent
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.EqualValues(t, 0, obj)
+				},
+			},
+			"deregistered_by_die": {
+				data: func() any {
+					return HandleContainerKillEvent
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.EqualValues(t, 1, obj)
+				},
+			},
+			"drained_again_by_stop": {
+				data: func() any {
+					return func(ctx context.Context, cnt types.ContainerJSON) error {
+						return HandleContainerDrainEvent(ctx, cnt, period)
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.EqualValues(t, 1, obj)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
+
+func readConfig(t *testing.T, yaml string) *conf.Turu {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(yaml)))
+
+	var cfg conf.Turu
+	assert.NoError(t, v.Unmarshal(&cfg))
+
+	return &cfg
+}
+
+func TestInit(t *testing.T) {
+	withRegistries(t, RegistryCollection{})
+
+	dir := t.TempDir()
+	cfg := readConfig(t, fmt.Sprintf(`
+config:
+  prometheus-file-sd:
+    path: %[1]s/legacy.json
+registries:
+  targets-public:
+    type: prometheus-file-sd
+    path: %[1]s/public.json
+  apisix-internal:
+    type: apisix-etcd
+    endpoint: http://127.0.0.1:2379
+    timeout: 5s
+`, dir))
+	assert.NoError(t, Init(context.Background(), cfg))
+
+	// only configured type is available under its own name
+	assert.Len(t, availableRegistry, 3)
+	assert.IsType(t, &prometheus.RegistryFileSD{}, availableRegistry["prometheus-file-sd"])
+	assert.NotContains(t, availableRegistry, "apisix-etcd")
+	assert.IsType(t, &apisix.RegistryEtcd{}, availableRegistry["apisix-internal"])
+	assert.IsType(t, &prometheus.RegistryFileSD{}, availableRegistry["targets-public"])
+
+	cnt := newContainer("prometheus-file-sd,targets-public")
+	cnt.Config.ExposedPorts = map[nat.Port]struct{}{"9090/tcp": {}}
+	assert.NoError(t, HandleContainerCreateEvent(context.Background(), cnt))
+	assert.FileExists(t, filepath.Join(dir, "legacy.json"))
+	assert.FileExists(t, filepath.Join(dir, "public.json"))
+
+	b, err := os.ReadFile(filepath.Join(dir, "public.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "whoami-1:9090")
+
+	// registry without config is skipped instead of constructed unconfigured
+	assert.NoError(t, HandleContainerCreateEvent(context.Background(), newContainer("consul")))
+}
+
+func TestInitInvalid(t *testing.T) {
+	withRegistries(t, RegistryCollection{})
+
+	cfg := readConfig(t, `
+registries:
+  unknown:
+    type: eureka-v3
+`)
+	assert.EqualError(t, Init(context.Background(), cfg), `registries.unknown: unknown registry type "eureka-v3"`)
+
+	cfg = readConfig(t, `
+registries:
+  leased:
+    type: etcd
+    ttl: forever
+`)
+	assert.ErrorContains(t, Init(context.Background(), cfg), "registries.leased:")
+	// failed init keep previous registries
+	assert.Empty(t, availableRegistry)
+}
@@ -0,0 +1,278 @@
+package traefik
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker"
+	"github.com/praswicaksono/turu/internal/fileutil"
+)
+
+type RegistryFile struct {
+	cfg *conf.TraefikFile
+	m   *sync.Mutex
+}
+
+func NewRegistryFile(cfg *conf.TraefikFile) *RegistryFile {
+	return &RegistryFile{cfg: cfg}
+}
+
+func (p *RegistryFile) Construct(ctx context.Context) {
+	if p.m == nil {
+		p.m = &sync.Mutex{}
+	}
+}
+
+func isToml(path string) bool {
+	return filepath.Ext(path) == ".toml"
+}
+
+func (p *RegistryFile) path() (string, error) {
+	if p.cfg == nil || p.cfg.Path == "" {
+		return "", errors.New("traefik-file.path could not be empty")
+	}
+
+	return p.cfg.Path, nil
+}
+
+// readConfig read dynamic configuration, missing file treated as empty configuration
+func (p *RegistryFile) readConfig(path string) (Config, error) {
+	cfg := Config{}
+
+	f, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if isToml(path) {
+		err = toml.Unmarshal(f, &cfg)
+	} else {
+		err = yaml.Unmarshal(f, &cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (p *RegistryFile) writeConfig(path string, cfg Config) error {
+	var (
+		s   []byte
+		err error
+	)
+
+	if isToml(path) {
+		s, err = toml.Marshal(cfg)
+	} else {
+		s, err = yaml.Marshal(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(path, s, 0644)
+}
+
+// section return table under key, it is created when missing and create is
+// true, otherwise nil is returned
+func section(m map[string]any, key string, create bool) (map[string]any, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		if !create {
+			return nil, nil
+		}
+		s := map[string]any{}
+		m[key] = s
+		return s, nil
+	}
+
+	s, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a table", key)
+	}
+
+	return s, nil
+}
+
+// httpEntries return routers and services tables of http section
+func httpEntries(cfg Config, create bool) (routers map[string]any, services map[string]any, err error) {
+	http, err := section(cfg, "http", create)
+	if err != nil || http == nil {
+		return nil, nil, err
+	}
+
+	routers, err = section(http, "routers", create)
+	if err != nil {
+		return nil, nil, err
+	}
+	services, err = section(http, "services", create)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return routers, services, nil
+}
+
+// removeEmpty remove http tables left without entry
+func removeEmpty(cfg Config) {
+	http, ok := cfg["http"].(map[string]any)
+	if !ok {
+		return
+	}
+
+	for _, k := range []string{"routers", "services"} {
+		if v, ok := http[k].(map[string]any); ok && len(v) == 0 {
+			delete(http, k)
+		}
+	}
+	if len(http) == 0 {
+		delete(cfg, "http")
+	}
+}
+
+// encode convert entry to generic table, yaml keep integer as integer
+func encode(v any) (map[string]any, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	return m, yaml.Unmarshal(b, &m)
+}
+
+// decode convert generic table read from file to entry
+func decode(m any, v any) error {
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(b, v)
+}
+
+func (p *RegistryFile) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path, err := p.path()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := p.readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	r, svc, err := CreateRouter(c)
+	if err != nil {
+		return err
+	}
+
+	routers, services, err := httpEntries(cfg, true)
+	if err != nil {
+		return err
+	}
+
+	routers[r.Service], err = encode(r)
+	if err != nil {
+		return err
+	}
+
+	// merge servers if service exist
+	if v, ok := services[r.Service]; ok {
+		var curr Service
+		err = decode(v, &curr)
+		if err != nil {
+			return err
+		}
+
+		if curr.LoadBalancer != nil {
+			for _, v := range svc.LoadBalancer.Servers {
+				if !slices.Contains(curr.LoadBalancer.Servers, v) {
+					curr.LoadBalancer.Servers = append(curr.LoadBalancer.Servers, v)
+				}
+			}
+			svc = &curr
+		}
+	}
+
+	services[r.Service], err = encode(svc)
+	if err != nil {
+		return err
+	}
+
+	return p.writeConfig(path, cfg)
+}
+
+func (p *RegistryFile) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	path, err := p.path()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := p.readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	name, service := docker.GetContainerOrServiceName(c)
+	entry := EntryName(service)
+
+	routers, services, err := httpEntries(cfg, false)
+	if err != nil || services == nil {
+		return err
+	}
+
+	v, ok := services[entry]
+	if !ok {
+		return nil
+	}
+
+	var curr Service
+	err = decode(v, &curr)
+	if err != nil || curr.LoadBalancer == nil {
+		return err
+	}
+
+	removed := CreateServers(name, c)
+	servers := slices.DeleteFunc(slices.Clone(curr.LoadBalancer.Servers), func(s Server) bool {
+		return slices.Contains(removed, s)
+	})
+
+	if len(servers) == len(curr.LoadBalancer.Servers) {
+		return nil
+	}
+
+	// if there is no server left, delete the router and service
+	if len(servers) == 0 {
+		delete(services, entry)
+		delete(routers, entry)
+		removeEmpty(cfg)
+	} else {
+		curr.LoadBalancer.Servers = servers
+		services[entry], err = encode(curr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.writeConfig(path, cfg)
+}
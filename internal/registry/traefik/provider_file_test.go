@@ -0,0 +1,203 @@
+package traefik_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/traefik"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is file name, its content before registration and containers
+// deregistered after whoami-1 and whoami-2 registered
+type scenario struct {
+	file       string
+	content    string
+	deregister []string
+}
+
+// userEntries is configuration not managed by turu, including router named
+// after the service and entries unknown to turu
+var userEntries = map[string]string{
+	"dynamic.yaml": `http:
+  routers:
+    whoami:
+      rule: Host(` + "`admin.example.com`" + `)
+      service: canary
+      observability:
+        accessLogs: false
+  services:
+    canary:
+      weighted:
+        services:
+          - name: whoami
+            weight: 3
+          - name: turu-whoami
+            weight: 1
+tcp:
+  routers:
+    db:
+      rule: HostSNI(` + "`*`" + `)
+      service: db
+`,
+	"dynamic.toml": `[http.routers.whoami]
+rule = "Host(` + "`admin.example.com`" + `)"
+service = "canary"
+
+[http.routers.whoami.observability]
+accessLogs = false
+
+[http.services.canary.weighted]
+[[http.services.canary.weighted.services]]
+name = "whoami"
+weight = 3
+
+[[http.services.canary.weighted.services]]
+name = "turu-whoami"
+weight = 1
+
+[tcp.routers.db]
+rule = "HostSNI(` + "`*`" + `)"
+service = "db"
+`,
+}
+
+// parse read configuration written in format of file
+func parse(t *testing.T, file string, content string) traefik.Config {
+	cfg := traefik.Config{}
+	if filepath.Ext(file) == ".toml" {
+		assert.NoError(t, toml.Unmarshal([]byte(content), &cfg))
+	} else {
+		assert.NoError(t, yaml.Unmarshal([]byte(content), &cfg))
+	}
+	return cfg
+}
+
+func newContainer(name string) types.ContainerJSON {
+	return dockertest.Container{
+		Name: name,
+		Labels: map[string]string{
+			"turu.traefik.rule":        "Host(`example.com`)",
+			"turu.traefik.entrypoints": "web, websecure",
+		},
+	}.Build()
+}
+
+func TestRegistryFile(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			sc := data.(scenario)
+			path := filepath.Join(t.TempDir(), sc.file)
+
+			if sc.content != "" {
+				if err := os.WriteFile(path, []byte(sc.content), 0644); err != nil {
+					return nil, err
+				}
+			}
+
+			ctx := context.Background()
+			r := traefik.NewRegistryFile(&conf.TraefikFile{Path: path})
+			r.Construct(ctx)
+
+			for _, name := range []string{"whoami-1", "whoami-2"} {
+				if err := r.Register(ctx, newContainer(name)); err != nil {
+					return nil, err
+				}
+			}
+			for _, name := range sc.deregister {
+				if err := r.Deregister(ctx, newContainer(name)); err != nil {
+					return nil, err
+				}
+			}
+
+			b, err := os.ReadFile(path)
+			return string(b), err
+		},
+		assertion: map[string]TestAssertion{},
+	}
+
+	for _, file := range []string{"dynamic.yaml", "dynamic.toml"} {
+		table.assertion["register_"+file] = TestAssertion{
+			data: func() any {
+				return scenario{file: file}
+			},
+			expectation: func(obj any, err error) {
+				assert.NoError(t, err)
+				assert.Contains(t, obj, "http://whoami-1:80")
+				assert.Contains(t, obj, "http://whoami-2:80")
+				assert.Contains(t, obj, "Host(`example.com`)")
+				assert.Contains(t, obj, "websecure")
+				assert.Contains(t, obj, "turu-whoami")
+			},
+		}
+		table.assertion["keep_user_entries_"+file] = TestAssertion{
+			data: func() any {
+				return scenario{file: file, content: userEntries[file]}
+			},
+			expectation: func(obj any, err error) {
+				assert.NoError(t, err)
+
+				cfg := parse(t, file, obj.(string))
+				user := parse(t, file, userEntries[file])
+				http := cfg["http"].(map[string]any)
+				userHTTP := user["http"].(map[string]any)
+
+				assert.Equal(t, user["tcp"], cfg["tcp"])
+				assert.Equal(t, userHTTP["routers"].(map[string]any)["whoami"], http["routers"].(map[string]any)["whoami"])
+				assert.Equal(t, userHTTP["services"].(map[string]any)["canary"], http["services"].(map[string]any)["canary"])
+				assert.Contains(t, http["routers"], "turu-whoami")
+			},
+		}
+		table.assertion["deregister_last_keep_user_entries_"+file] = TestAssertion{
+			data: func() any {
+				return scenario{file: file, content: userEntries[file], deregister: []string{"whoami-1", "whoami-2"}}
+			},
+			expectation: func(obj any, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, parse(t, file, userEntries[file]), parse(t, file, obj.(string)))
+			},
+		}
+		table.assertion["deregister_"+file] = TestAssertion{
+			data: func() any {
+				return scenario{file: file, deregister: []string{"whoami-1"}}
+			},
+			expectation: func(obj any, err error) {
+				assert.NoError(t, err)
+				assert.NotContains(t, obj, "http://whoami-1:80")
+				assert.Contains(t, obj, "http://whoami-2:80")
+			},
+		}
+		table.assertion["deregister_last_"+file] = TestAssertion{
+			data: func() any {
+				return scenario{file: file, deregister: []string{"whoami-1", "whoami-2"}}
+			},
+			expectation: func(obj any, err error) {
+				assert.NoError(t, err)
+				assert.NotContains(t, obj, "whoami")
+			},
+		}
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package traefik
+
+// Config is traefik dynamic configuration as read from file, it is kept as
+// generic map so sections and fields unknown to turu survive rewrite
+type Config = map[string]any
+
+type Router struct {
+	EntryPoints []string `json:"entryPoints,omitempty" toml:"entryPoints,omitempty"`
+	Middlewares []string `json:"middlewares,omitempty" toml:"middlewares,omitempty"`
+	Service     string   `json:"service" toml:"service"`
+	Rule        string   `json:"rule" toml:"rule"`
+	Priority    int      `json:"priority,omitempty" toml:"priority,omitempty"`
+	TLS         any      `json:"tls,omitempty" toml:"tls,omitempty"`
+}
+
+type Service struct {
+	LoadBalancer *LoadBalancer `json:"loadBalancer,omitempty" toml:"loadBalancer,omitempty"`
+}
+
+type LoadBalancer struct {
+	Servers        []Server `json:"servers" toml:"servers"`
+	PassHostHeader *bool    `json:"passHostHeader,omitempty" toml:"passHostHeader,omitempty"`
+	HealthCheck    any      `json:"healthCheck,omitempty" toml:"healthCheck,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url" toml:"url"`
+}
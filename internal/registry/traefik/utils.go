@@ -0,0 +1,84 @@
+package traefik
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gookit/goutil"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+var (
+	LABEL_TURU_TRAEFIK_RULE        = "turu.traefik.rule"
+	LABEL_TURU_TRAEFIK_ENTRYPOINTS = "turu.traefik.entrypoints"
+	LABEL_TURU_TRAEFIK_MIDDLEWARES = "turu.traefik.middlewares"
+	LABEL_TURU_TRAEFIK_SCHEME      = "turu.traefik.scheme"
+
+	// ENTRY_PREFIX mark routers and services owned by turu, entries without
+	// it are never changed
+	ENTRY_PREFIX = "turu-"
+)
+
+func IsTraefikEnabled(cnt types.ContainerJSON) bool {
+	return goutil.Contains(cnt.Config.Labels, LABEL_TURU_TRAEFIK_RULE)
+}
+
+// EntryName return name of router and service owned by turu for service
+func EntryName(service string) string {
+	return ENTRY_PREFIX + service
+}
+
+// CreateRouter create router and service of container, router and service
+// are named after container service with ENTRY_PREFIX
+func CreateRouter(cnt types.ContainerJSON) (*Router, *Service, error) {
+	if !IsTraefikEnabled(cnt) {
+		return nil, nil, errors.New("traefik not enabled")
+	}
+
+	name, service := docker.GetContainerOrServiceName(cnt)
+	labels := cnt.Config.Labels
+
+	r := &Router{
+		Service:     EntryName(service),
+		Rule:        labels[LABEL_TURU_TRAEFIK_RULE],
+		EntryPoints: splitLabel(labels[LABEL_TURU_TRAEFIK_ENTRYPOINTS]),
+		Middlewares: splitLabel(labels[LABEL_TURU_TRAEFIK_MIDDLEWARES]),
+	}
+
+	return r, &Service{
+		LoadBalancer: &LoadBalancer{
+			Servers: CreateServers(name, cnt),
+		},
+	}, nil
+}
+
+// CreateServers create load balancer server for every exposed port of container
+func CreateServers(name string, cnt types.ContainerJSON) []Server {
+	scheme := "http"
+	if v, ok := cnt.Config.Labels[LABEL_TURU_TRAEFIK_SCHEME]; ok {
+		scheme = v
+	}
+
+	var servers []Server
+	for _, v := range docker.GetLoadBalancerURL(name, cnt) {
+		if v == "" {
+			continue
+		}
+		servers = append(servers, Server{URL: fmt.Sprintf("%s://%s", scheme, v)})
+	}
+
+	return servers
+}
+
+func splitLabel(v string) []string {
+	var res []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
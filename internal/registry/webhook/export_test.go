@@ -0,0 +1,11 @@
+package webhook
+
+// Wait block until every queued payload is delivered or dead lettered
+func (p *RegistryWebhook) Wait() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for p.pending > 0 {
+		p.idle.Wait()
+	}
+}
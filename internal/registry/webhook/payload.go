@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+const (
+	EventRegister   = "register"
+	EventDeregister = "deregister"
+
+	HeaderEvent     = "X-Turu-Event"
+	HeaderSignature = "X-Turu-Signature"
+)
+
+// Payload is json body posted to webhook
+type Payload struct {
+	Event       string            `json:"event"`
+	Service     string            `json:"service"`
+	Container   string            `json:"container"`
+	ContainerID string            `json:"container_id"`
+	Nodes       []string          `json:"nodes"`
+	Labels      map[string]string `json:"labels"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+// CreatePayload create payload of container event, only turu.* labels are sent
+func CreatePayload(event string, cnt types.ContainerJSON) *Payload {
+	name, service := docker.GetContainerOrServiceName(cnt)
+
+	p := &Payload{
+		Event:       event,
+		Service:     service,
+		Container:   name,
+		ContainerID: cnt.ID,
+		Nodes:       []string{},
+		Labels:      make(map[string]string),
+		Timestamp:   time.Now().UTC(),
+	}
+
+	for _, v := range docker.GetLoadBalancerURL(name, cnt) {
+		if v != "" {
+			p.Nodes = append(p.Nodes, v)
+		}
+	}
+
+	for k, v := range cnt.Config.Labels {
+		if strings.HasPrefix(k, "turu.") {
+			p.Labels[k] = v
+		}
+	}
+
+	return p
+}
+
+// Sign return hex encoded hmac sha256 of body prefixed by algorithm, receiver
+// compare it with X-Turu-Signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+	defaultTimeout = 5 * time.Second
+	queueSize      = 1024
+)
+
+// RegistryWebhook post container event to configured urls, event which could
+// not be delivered after retries is appended to dead letter file. Events are
+// delivered in order by single goroutine so retries does not hold container
+// event workers.
+type RegistryWebhook struct {
+	cfg *conf.Webhook
+	c   *http.Client
+	m   *sync.Mutex
+
+	// registered is id of containers whose register event was sent, several
+	// stopping events of one container send single deregister event
+	registered map[string]struct{}
+	queue      chan *Payload
+
+	// pending is number of queued payloads not yet delivered, idle is
+	// signalled when it drops to zero, both are guarded by m
+	pending int
+	idle    *sync.Cond
+}
+
+func NewRegistryWebhook(cfg *conf.Webhook) *RegistryWebhook {
+	return &RegistryWebhook{cfg: cfg}
+}
+
+// deadLetter is single line of dead letter file
+type deadLetter struct {
+	URL     string   `json:"url"`
+	Error   string   `json:"error"`
+	Payload *Payload `json:"payload"`
+}
+
+func (p *RegistryWebhook) Construct(ctx context.Context) {
+	if p.c == nil {
+		p.m = &sync.Mutex{}
+		p.idle = sync.NewCond(p.m)
+		p.c = &http.Client{Timeout: defaultTimeout}
+		if cfg := p.cfg; cfg != nil && cfg.Timeout > 0 {
+			p.c.Timeout = cfg.Timeout
+		}
+		p.registered = make(map[string]struct{})
+		p.queue = make(chan *Payload, queueSize)
+
+		go p.run(ctx)
+	}
+}
+
+// run deliver queued payloads until ctx is done
+func (p *RegistryWebhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-p.queue:
+			err := p.send(ctx, payload)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("event", payload.Event).Str("container", payload.Container).Msg("webhook delivery failed")
+			}
+			p.done()
+		}
+	}
+}
+
+// done mark single queued payload handled
+func (p *RegistryWebhook) done() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.pending--
+	if p.pending == 0 {
+		p.idle.Broadcast()
+	}
+}
+
+// enqueue queue payload for delivery, it blocks while the queue is full
+func (p *RegistryWebhook) enqueue(ctx context.Context, payload *Payload) error {
+	if _, err := p.config(); err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	p.pending++
+	p.m.Unlock()
+
+	select {
+	case p.queue <- payload:
+		return nil
+	case <-ctx.Done():
+		p.done()
+		return ctx.Err()
+	}
+}
+
+func (p *RegistryWebhook) config() (*conf.Webhook, error) {
+	cfg := p.cfg
+	if cfg == nil || len(cfg.URLs) == 0 {
+		return nil, errors.New("webhook.urls could not be empty")
+	}
+
+	return cfg, nil
+}
+
+func (p *RegistryWebhook) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	p.registered[c.ID] = struct{}{}
+	p.m.Unlock()
+
+	return p.enqueue(ctx, CreatePayload(EventRegister, c))
+}
+
+func (p *RegistryWebhook) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	_, ok := p.registered[c.ID]
+	delete(p.registered, c.ID)
+	p.m.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return p.enqueue(ctx, CreatePayload(EventDeregister, c))
+}
+
+// send deliver payload to every url, failure of one url does not stop
+// delivery to the others
+func (p *RegistryWebhook) send(ctx context.Context, payload *Payload) error {
+	cfg, err := p.config()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, url := range cfg.URLs {
+		err := p.deliver(ctx, cfg, url, payload.Event, body)
+		if err == nil {
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("webhook %s: %w", url, err))
+
+		if derr := p.writeDeadLetter(cfg, url, err, payload); derr != nil {
+			errs = append(errs, derr)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver post body to url, network error, 429 and 5xx are retried with
+// exponential backoff
+func (p *RegistryWebhook) deliver(ctx context.Context, cfg *conf.Webhook, url string, event string, body []byte) error {
+	retries := cfg.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+
+	backoff := cfg.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			log.Ctx(ctx).Warn().Err(err).Str("url", url).Int("attempt", attempt).Dur("retry_in", backoff).Msg("webhook delivery failed")
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			backoff = min(backoff*2, maxBackoff)
+		}
+
+		var retry bool
+		retry, err = p.post(ctx, cfg, url, event, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// post send single request, it returns whether failed request worth retrying
+func (p *RegistryWebhook) post(ctx context.Context, cfg *conf.Webhook, url string, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "turu")
+	req.Header.Set(HeaderEvent, event)
+	if cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(cfg.Secret, body))
+	}
+
+	res, err := p.c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return false, nil
+}
+
+// writeDeadLetter append undeliverable payload as json line, nothing is
+// written when dead letter file is not configured
+func (p *RegistryWebhook) writeDeadLetter(cfg *conf.Webhook, url string, err error, payload *Payload) error {
+	if cfg.DeadLetter == "" {
+		return nil
+	}
+
+	b, jerr := json.Marshal(deadLetter{URL: url, Error: err.Error(), Payload: payload})
+	if jerr != nil {
+		return jerr
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	f, ferr := os.OpenFile(cfg.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr != nil {
+		return ferr
+	}
+	defer f.Close()
+
+	_, ferr = f.Write(append(b, '\n'))
+
+	return ferr
+}
@@ -0,0 +1,225 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReceiver record delivered payload and fail the first n requests
+type fakeReceiver struct {
+	m        sync.Mutex
+	fail     int
+	status   int
+	requests int
+	payloads []webhook.Payload
+	valid    bool
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.requests++
+	if f.requests <= f.fail {
+		w.WriteHeader(f.status)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	f.valid = r.Header.Get(webhook.HeaderSignature) == webhook.Sign("secret", body)
+
+	var p webhook.Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.payloads = append(f.payloads, p)
+}
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+// scenario is receivers behind webhook urls and registry calls of single test
+// case
+type scenario struct {
+	receivers []*fakeReceiver
+	run       func(ctx context.Context, r *webhook.RegistryWebhook) error
+}
+
+// result is receivers, their urls and dead letter file path after scenario
+// run
+type result struct {
+	receivers  []*fakeReceiver
+	urls       []string
+	deadLetter string
+}
+
+func newContainer() types.ContainerJSON {
+	return dockertest.Container{
+		Labels: map[string]string{
+			"turu.registry": "webhook",
+			"maintainer":    "someone",
+		},
+	}.Build()
+}
+
+func TestRegistryWebhook(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			sc := data.(scenario)
+
+			var urls []string
+			for _, recv := range sc.receivers {
+				srv := httptest.NewServer(recv)
+				defer srv.Close()
+				urls = append(urls, srv.URL)
+			}
+
+			deadLetter := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+			r := webhook.NewRegistryWebhook(&conf.Webhook{
+				URLs:       urls,
+				Secret:     "secret",
+				Retries:    2,
+				Backoff:    time.Millisecond,
+				DeadLetter: deadLetter,
+			})
+
+			ctx := context.Background()
+			r.Construct(ctx)
+
+			err := sc.run(ctx, r)
+			r.Wait()
+			return result{receivers: sc.receivers, urls: urls, deadLetter: deadLetter}, err
+		},
+		assertion: map[string]TestAssertion{
+			"retry_until_delivered": {
+				data: func() any {
+					return scenario{
+						receivers: []*fakeReceiver{{fail: 2, status: http.StatusServiceUnavailable}},
+						run: func(ctx context.Context, r *webhook.RegistryWebhook) error {
+							if err := r.Register(ctx, newContainer()); err != nil {
+								return err
+							}
+							return r.Deregister(ctx, newContainer())
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					recv := res.receivers[0]
+					assert.NoError(t, err)
+					// succeed on the last retry
+					assert.Equal(t, 4, recv.requests)
+					assert.True(t, recv.valid)
+					assert.Len(t, recv.payloads, 2)
+
+					p := recv.payloads[0]
+					assert.Equal(t, webhook.EventRegister, p.Event)
+					assert.Equal(t, "whoami", p.Service)
+					assert.Equal(t, "whoami-1", p.Container)
+					assert.Equal(t, "whoami-1", p.ContainerID)
+					assert.Equal(t, []string{"whoami-1:80"}, p.Nodes)
+					assert.Equal(t, map[string]string{"turu.service": "whoami", "turu.registry": "webhook"}, p.Labels)
+					assert.Equal(t, webhook.EventDeregister, recv.payloads[1].Event)
+
+					_, err = os.Stat(res.deadLetter)
+					assert.ErrorIs(t, err, os.ErrNotExist)
+				},
+			},
+			"client_error_dead_letter": {
+				data: func() any {
+					return scenario{
+						receivers: []*fakeReceiver{{fail: 100, status: http.StatusBadRequest}, {}},
+						run: func(ctx context.Context, r *webhook.RegistryWebhook) error {
+							return r.Register(ctx, newContainer())
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					res := obj.(result)
+					// delivery failure is not reported to container event
+					assert.NoError(t, err)
+
+					// client error is not retried and does not stop delivery to
+					// other url
+					assert.Equal(t, 1, res.receivers[0].requests)
+					assert.Len(t, res.receivers[1].payloads, 1)
+
+					b, err := os.ReadFile(res.deadLetter)
+					assert.NoError(t, err)
+					assert.Equal(t, 1, strings.Count(string(b), "\n"))
+					assert.Contains(t, string(b), `"url":"`+res.urls[0]+`"`)
+					assert.Contains(t, string(b), `"error":"unexpected status 400"`)
+					assert.Contains(t, string(b), `"event":"register"`)
+				},
+			},
+			"deregister_once": {
+				data: func() any {
+					return scenario{
+						receivers: []*fakeReceiver{{}},
+						run: func(ctx context.Context, r *webhook.RegistryWebhook) error {
+							if err := r.Register(ctx, newContainer()); err != nil {
+								return err
+							}
+							// kill, die and stop of the same container
+							for range 3 {
+								if err := r.Deregister(ctx, newContainer()); err != nil {
+									return err
+								}
+							}
+							return nil
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					recv := obj.(result).receivers[0]
+					assert.NoError(t, err)
+					assert.Len(t, recv.payloads, 2)
+					assert.Equal(t, webhook.EventDeregister, recv.payloads[1].Event)
+				},
+			},
+			"deregister_not_registered": {
+				data: func() any {
+					return scenario{
+						receivers: []*fakeReceiver{{}},
+						run: func(ctx context.Context, r *webhook.RegistryWebhook) error {
+							return r.Deregister(ctx, newContainer())
+						},
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Equal(t, 0, obj.(result).receivers[0].requests)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
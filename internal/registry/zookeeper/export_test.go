@@ -0,0 +1,12 @@
+package zookeeper
+
+import (
+	"github.com/go-zookeeper/zk"
+	"github.com/praswicaksono/turu/internal/conf"
+)
+
+// NewRegistryZookeeperWithConn create registry using the given connection and
+// its session events instead of connecting to cfg servers
+func NewRegistryZookeeperWithConn(cfg *conf.Zookeeper, zc Conn, events <-chan zk.Event) *RegistryZookeeper {
+	return &RegistryZookeeper{cfg: cfg, zc: zc, events: events}
+}
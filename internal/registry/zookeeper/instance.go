@@ -0,0 +1,48 @@
+package zookeeper
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/praswicaksono/turu/internal/docker"
+)
+
+// ServiceInstance is curator service discovery instance format, java consumer
+// using ServiceDiscovery read it from children of <base-path>/<service>
+type ServiceInstance struct {
+	Name                string            `json:"name"`
+	ID                  string            `json:"id"`
+	Address             string            `json:"address"`
+	Port                int               `json:"port"`
+	SSLPort             *int              `json:"sslPort"`
+	Payload             map[string]string `json:"payload"`
+	RegistrationTimeUTC int64             `json:"registrationTimeUTC"`
+	ServiceType         string            `json:"serviceType"`
+	URISpec             *string           `json:"uriSpec"`
+}
+
+// CreateInstances create instance for every exposed port of container,
+// container id and name are sent as payload
+func CreateInstances(cnt types.ContainerJSON) []ServiceInstance {
+	name, service := docker.GetContainerOrServiceName(cnt)
+
+	payload := map[string]string{
+		"containerId": cnt.ID,
+		"container":   name,
+	}
+
+	var instances []ServiceInstance
+	for port := range cnt.Config.ExposedPorts {
+		instances = append(instances, ServiceInstance{
+			Name:                service,
+			ID:                  name + "-" + port.Port(),
+			Address:             name,
+			Port:                port.Int(),
+			Payload:             payload,
+			RegistrationTimeUTC: time.Now().UnixMilli(),
+			ServiceType:         "DYNAMIC",
+		})
+	}
+
+	return instances
+}
@@ -0,0 +1,66 @@
+package zookeeper_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/zookeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestAssertion struct {
+	data        func() any
+	expectation func(any, error)
+}
+
+type TestTable struct {
+	assertion map[string]TestAssertion
+	test      func(any) (any, error)
+}
+
+func TestCreateInstances(t *testing.T) {
+	table := TestTable{
+		test: func(data any) (any, error) {
+			instances := zookeeper.CreateInstances(data.(dockertest.Container).Build())
+			if len(instances) != 1 {
+				return len(instances), nil
+			}
+
+			b, err := json.Marshal(instances[0])
+			if err != nil {
+				return nil, err
+			}
+
+			var m map[string]any
+			return m, json.Unmarshal(b, &m)
+		},
+		assertion: map[string]TestAssertion{
+			"curator_format": {
+				data: func() any {
+					return dockertest.Container{}
+				},
+				expectation: func(obj any, err error) {
+					m := obj.(map[string]any)
+					assert.NoError(t, err)
+					assert.Equal(t, "whoami", m["name"])
+					assert.Equal(t, "whoami-1-80", m["id"])
+					assert.Equal(t, "whoami-1", m["address"])
+					assert.Equal(t, float64(80), m["port"])
+					assert.Equal(t, "DYNAMIC", m["serviceType"])
+					assert.Equal(t, map[string]any{"containerId": "whoami-1", "container": "whoami-1"}, m["payload"])
+					// curator expect the keys even when empty
+					assert.Contains(t, m, "sslPort")
+					assert.Contains(t, m, "uriSpec")
+					assert.NotZero(t, m["registrationTimeUTC"])
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}
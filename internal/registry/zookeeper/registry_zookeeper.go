@@ -0,0 +1,227 @@
+package zookeeper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-zookeeper/zk"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultBasePath       = "/services"
+	defaultSessionTimeout = 10 * time.Second
+)
+
+// registration is znode created for single instance
+type registration struct {
+	service string
+	data    []byte
+	path    string
+}
+
+// Conn is zookeeper connection operations used by the registry
+type Conn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Delete(path string, version int32) error
+	SessionID() int64
+	Close()
+}
+
+// RegistryZookeeper create ephemeral sequential znode for every instance under
+// <base-path>/<service>/, znodes vanish with turu session. When the session
+// expires, znodes are created again within the new session.
+type RegistryZookeeper struct {
+	cfg      *conf.Zookeeper
+	zc       Conn
+	events   <-chan zk.Event
+	basePath string
+
+	m             *sync.Mutex
+	registrations map[string][]*registration
+}
+
+func NewRegistryZookeeper(cfg *conf.Zookeeper) *RegistryZookeeper {
+	return &RegistryZookeeper{cfg: cfg}
+}
+
+type zkLogger struct{}
+
+func (zkLogger) Printf(format string, args ...any) {
+	log.Debug().Msgf(format, args...)
+}
+
+func (p *RegistryZookeeper) Construct(ctx context.Context) {
+	if p.m != nil {
+		return
+	}
+
+	cfg := p.cfg
+	if cfg == nil || (len(cfg.Servers) == 0 && p.zc == nil) {
+		log.Fatal().Msg("zookeeper.servers could not be empty")
+	}
+
+	if p.zc == nil {
+		timeout := cfg.SessionTimeout
+		if timeout == 0 {
+			timeout = defaultSessionTimeout
+		}
+
+		zc, events, err := zk.Connect(cfg.Servers, timeout, zk.WithLogger(zkLogger{}))
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		p.zc, p.events = zc, events
+	}
+
+	p.basePath = "/" + strings.Trim(cfg.BasePath, "/")
+	if p.basePath == "/" {
+		p.basePath = defaultBasePath
+	}
+	p.m = &sync.Mutex{}
+	p.registrations = make(map[string][]*registration)
+
+	go p.watchSession(p.events)
+
+	// closing the connection end the session and its event channel
+	go func() {
+		<-ctx.Done()
+		p.zc.Close()
+	}()
+}
+
+// watchSession create znodes again once new session established after the
+// previous one expired
+func (p *RegistryZookeeper) watchSession(events <-chan zk.Event) {
+	var session int64
+
+	for e := range events {
+		if e.Type != zk.EventSession || e.State != zk.StateHasSession {
+			continue
+		}
+
+		prev := session
+		session = p.zc.SessionID()
+		if prev == 0 || prev == session {
+			continue
+		}
+
+		log.Warn().Int64("session", session).Msg("zookeeper session expired, registering znodes again")
+
+		err := p.recreate()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to register znodes in new zookeeper session")
+		}
+	}
+}
+
+func (p *RegistryZookeeper) recreate() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var errs []error
+	for _, regs := range p.registrations {
+		for _, r := range regs {
+			errs = append(errs, p.create(r))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ensurePath create persistent parent znodes
+func (p *RegistryZookeeper) ensurePath(dir string) error {
+	cur := ""
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		cur += "/" + part
+		_, err := p.zc.Create(cur, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *RegistryZookeeper) create(r *registration) error {
+	dir := path.Join(p.basePath, r.service)
+
+	err := p.ensurePath(dir)
+	if err != nil {
+		return err
+	}
+
+	created, err := p.zc.Create(dir+"/instance-", r.data, zk.FlagEphemeralSequential, zk.WorldACL(zk.PermAll))
+	if err != nil {
+		return err
+	}
+
+	r.path = created
+
+	return nil
+}
+
+func (p *RegistryZookeeper) Register(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if _, ok := p.registrations[c.ID]; ok {
+		return nil
+	}
+
+	var regs []*registration
+	for _, i := range CreateInstances(c) {
+		b, err := json.Marshal(i)
+		if err != nil {
+			return err
+		}
+
+		r := &registration{service: i.Name, data: b}
+		err = p.create(r)
+		if err != nil {
+			// remove partially created znodes so next event register all of them
+			for _, created := range regs {
+				if derr := p.zc.Delete(created.path, -1); derr != nil && !errors.Is(derr, zk.ErrNoNode) {
+					log.Ctx(ctx).Error().Err(derr).Str("znode", created.path).Msg("failed to remove znode")
+				}
+			}
+			return err
+		}
+
+		log.Ctx(ctx).Debug().Str("znode", r.path).Msg("znode created")
+		regs = append(regs, r)
+	}
+
+	p.registrations[c.ID] = regs
+
+	return nil
+}
+
+func (p *RegistryZookeeper) Deregister(ctx context.Context, c types.ContainerJSON) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	regs, ok := p.registrations[c.ID]
+
+	if !ok {
+		return nil
+	}
+
+	for _, r := range regs {
+		err := p.zc.Delete(r.path, -1)
+		if err != nil && !errors.Is(err, zk.ErrNoNode) {
+			return err
+		}
+	}
+
+	delete(p.registrations, c.ID)
+
+	return nil
+}
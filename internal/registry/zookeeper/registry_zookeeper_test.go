@@ -0,0 +1,275 @@
+package zookeeper_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/praswicaksono/turu/internal/conf"
+	"github.com/praswicaksono/turu/internal/docker/dockertest"
+	"github.com/praswicaksono/turu/internal/registry/zookeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+var errCreate = errors.New("create failed")
+
+// fakeConn keep znodes in memory, ephemeral znodes live until expire is called
+type fakeConn struct {
+	m         sync.Mutex
+	session   int64
+	seq       int
+	creates   int
+	failAt    int
+	nodes     map[string][]byte
+	ephemeral map[string]bool
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		session:   1,
+		nodes:     make(map[string][]byte),
+		ephemeral: make(map[string]bool),
+	}
+}
+
+func (f *fakeConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if flags&zk.FlagSequence == 0 {
+		if _, ok := f.nodes[path]; ok {
+			return "", zk.ErrNodeExists
+		}
+		f.nodes[path] = data
+		return path, nil
+	}
+
+	// failAt is counted on instance znodes only
+	f.creates++
+	if f.creates == f.failAt {
+		return "", errCreate
+	}
+
+	path = fmt.Sprintf("%s%010d", path, f.seq)
+	f.seq++
+	f.nodes[path] = data
+	f.ephemeral[path] = flags&zk.FlagEphemeral != 0
+
+	return path, nil
+}
+
+func (f *fakeConn) Delete(path string, version int32) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if _, ok := f.nodes[path]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(f.nodes, path)
+	delete(f.ephemeral, path)
+
+	return nil
+}
+
+func (f *fakeConn) SessionID() int64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.session
+}
+
+func (f *fakeConn) Close() {}
+
+// expire remove ephemeral znodes and start new session, like zookeeper does
+// once session timeout passed without heartbeat
+func (f *fakeConn) expire() {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	for path, ephemeral := range f.ephemeral {
+		if ephemeral {
+			delete(f.nodes, path)
+			delete(f.ephemeral, path)
+		}
+	}
+	f.session++
+}
+
+// instances return data of instance znodes keyed by path
+func (f *fakeConn) instances() map[string]string {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	instances := make(map[string]string, len(f.ephemeral))
+	for path := range f.ephemeral {
+		instances[path] = string(f.nodes[path])
+	}
+
+	return instances
+}
+
+// scenario is registry calls of single test case, events is session events
+// read by registry
+type scenario = func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error
+
+var hasSession = zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+
+func register(cnt dockertest.Container) scenario {
+	return func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error {
+		return r.Register(ctx, cnt.Build())
+	}
+}
+
+func TestRegistryZookeeper(t *testing.T) {
+	replicas := dockertest.Container{Ports: []string{"80/tcp", "443/tcp"}}
+
+	table := TestTable{
+		test: func(data any) (any, error) {
+			f := newFakeConn()
+			events := make(chan zk.Event)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			r := zookeeper.NewRegistryZookeeperWithConn(&conf.Zookeeper{BasePath: "/turu"}, f, events)
+			r.Construct(ctx)
+
+			err := data.(scenario)(ctx, r, f, events)
+			close(events)
+
+			return f.instances(), err
+		},
+		assertion: map[string]TestAssertion{
+			"register": {
+				data: func() any {
+					return register(replicas)
+				},
+				expectation: func(obj any, err error) {
+					instances := obj.(map[string]string)
+					assert.NoError(t, err)
+					assert.Len(t, instances, 2)
+					assert.Contains(t, instances, "/turu/whoami/instance-0000000000")
+					assert.Contains(t, instances, "/turu/whoami/instance-0000000001")
+				},
+			},
+			"register_twice": {
+				data: func() any {
+					return func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error {
+						if err := register(replicas)(ctx, r, f, events); err != nil {
+							return err
+						}
+						return register(replicas)(ctx, r, f, events)
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Len(t, obj, 2)
+				},
+			},
+			"deregister_twice": {
+				data: func() any {
+					return func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error {
+						if err := register(replicas)(ctx, r, f, events); err != nil {
+							return err
+						}
+						if err := r.Deregister(ctx, replicas.Build()); err != nil {
+							return err
+						}
+						return r.Deregister(ctx, replicas.Build())
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj)
+				},
+			},
+			"partial_failure_rollback": {
+				data: func() any {
+					return func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error {
+						f.failAt = 2
+						return register(replicas)(ctx, r, f, events)
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.ErrorIs(t, err, errCreate)
+					assert.Empty(t, obj)
+				},
+			},
+			"partial_failure_register_again": {
+				data: func() any {
+					return func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error {
+						f.failAt = 2
+						if err := register(replicas)(ctx, r, f, events); !errors.Is(err, errCreate) {
+							return fmt.Errorf("expected create to fail, got %v", err)
+						}
+						return register(replicas)(ctx, r, f, events)
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Len(t, obj, 2)
+				},
+			},
+			"session_expired_recreate": {
+				data: func() any {
+					return func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error {
+						events <- hasSession
+						if err := register(replicas)(ctx, r, f, events); err != nil {
+							return err
+						}
+
+						f.expire()
+						events <- hasSession
+
+						assert.Eventually(t, func() bool {
+							return len(f.instances()) == 2
+						}, 5*time.Second, 10*time.Millisecond)
+						return nil
+					}
+				},
+				expectation: func(obj any, err error) {
+					instances := obj.(map[string]string)
+					assert.NoError(t, err)
+					assert.Len(t, instances, 2)
+					assert.Contains(t, instances, "/turu/whoami/instance-0000000002")
+					assert.Contains(t, instances, "/turu/whoami/instance-0000000003")
+				},
+			},
+			"session_expired_deregistered_not_recreated": {
+				data: func() any {
+					return func(ctx context.Context, r *zookeeper.RegistryZookeeper, f *fakeConn, events chan<- zk.Event) error {
+						events <- hasSession
+						if err := register(replicas)(ctx, r, f, events); err != nil {
+							return err
+						}
+						if err := r.Deregister(ctx, replicas.Build()); err != nil {
+							return err
+						}
+
+						f.expire()
+						events <- hasSession
+
+						// events is unbuffered, the second event is handled
+						// once the registry read the next one
+						events <- zk.Event{Type: zk.EventSession, State: zk.StateConnected}
+						return nil
+					}
+				},
+				expectation: func(obj any, err error) {
+					assert.NoError(t, err)
+					assert.Empty(t, obj)
+				},
+			},
+		},
+	}
+
+	for k, v := range table.assertion {
+		t.Run(k, func(t *testing.T) {
+			v.expectation(table.test(v.data()))
+		})
+	}
+}